@@ -3,6 +3,7 @@ package cmd
 import (
 	"amdzy/gochain/pkg/blockchain"
 	"amdzy/gochain/pkg/utxo"
+	"amdzy/gochain/pkg/wallet"
 	"fmt"
 	"log"
 
@@ -17,7 +18,11 @@ func NewCreateBlockchainCommand() *cobra.Command {
 		Short: "-address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS",
 		Long:  "-address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS",
 		Run: func(cmd *cobra.Command, args []string) {
-			bc, err := blockchain.CreateBlockChain(address)
+			if !wallet.ValidateAddress(address, chainParams().AddressVersion) {
+				log.Fatal("Invalid address")
+			}
+
+			bc, err := blockchain.CreateBlockChain(networkDataDir(), address, chainParams())
 			if err != nil {
 				log.Fatal(err)
 			}
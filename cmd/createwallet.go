@@ -14,7 +14,7 @@ func NewCreateWalletCommand() *cobra.Command {
 		Short: "create wallet",
 		Long:  "create wallet",
 		Run: func(cmd *cobra.Command, args []string) {
-			wallets, err := wallet.NewWallets()
+			wallets, err := wallet.NewWallets(networkDataDir(), chainParams().AddressVersion)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/utxo"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func NewDumpTxOutSetCommand() *cobra.Command {
+	var out string
+
+	var dumpTxOutSetCmd = &cobra.Command{
+		Use:   "dumptxoutset",
+		Short: "Write a snapshot of the UTXO set",
+		Long:  "Write a snapshot of the UTXO set at the current tip that a new node can start from with loadtxoutset",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			file, err := os.Create(out)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			metadata, err := utxo.UTXOSet{Blockchain: bc}.DumpSnapshot(file)
+			if err != nil {
+				file.Close()
+				log.Fatal(err)
+			}
+
+			err = file.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Height: %d\n", metadata.Height)
+			fmt.Printf("Block: %x\n", metadata.BlockHash)
+			fmt.Printf("UTXO hash: %x\n", metadata.UTXOHash)
+			fmt.Printf("Transactions: %d\n", metadata.Transactions)
+			fmt.Printf("Done! Snapshot written to %s\n", out)
+		},
+	}
+
+	dumpTxOutSetCmd.Flags().StringVarP(&out, "out", "o", "", "File to write the snapshot to")
+	cobra.MarkFlagRequired(dumpTxOutSetCmd.Flags(), "out")
+
+	return dumpTxOutSetCmd
+}
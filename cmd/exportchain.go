@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/bootstrap"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const progressInterval = 100
+
+func NewExportChainCommand() *cobra.Command {
+	var out string
+
+	var exportChainCmd = &cobra.Command{
+		Use:   "exportchain",
+		Short: "Export the main chain to a bootstrap file",
+		Long:  "Export the main chain to a bootstrap file that can be loaded with importchain",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			file, err := os.Create(out)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			err = bootstrap.Export(bc, file, func(height, bestHeight int) {
+				if height%progressInterval == 0 || height == bestHeight {
+					fmt.Printf("Exported block %d of %d\n", height, bestHeight)
+				}
+			})
+			if err != nil {
+				file.Close()
+				log.Fatal(err)
+			}
+
+			err = file.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Done! Chain written to %s\n", out)
+		},
+	}
+
+	exportChainCmd.Flags().StringVarP(&out, "out", "o", "", "File to write the chain to")
+	cobra.MarkFlagRequired(exportChainCmd.Flags(), "out")
+
+	return exportChainCmd
+}
@@ -2,7 +2,9 @@ package cmd
 
 import (
 	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/spv"
 	"amdzy/gochain/pkg/utxo"
+	"amdzy/gochain/pkg/wallet"
 	"amdzy/gochain/utils"
 	"fmt"
 	"log"
@@ -12,36 +14,55 @@ import (
 
 func NewGetBalanceCommand() *cobra.Command {
 	var address string
+	var useSPV bool
 
 	var getBalanceCmd = &cobra.Command{
 		Use:   "getbalance",
 		Short: "--data BLOCK_DATA - add a block to the blockchain",
 		Long:  "--data BLOCK_DATA - add a block to the blockchain",
 		Run: func(cmd *cobra.Command, args []string) {
-			bc, err := blockchain.NewBlockchain()
-			if err != nil {
-				log.Fatal(err)
+			if !wallet.ValidateAddress(address, chainParams().AddressVersion) {
+				log.Fatal("Invalid address")
 			}
-			defer bc.CloseDB()
-			UTXOSet := utxo.UTXOSet{Blockchain: bc}
 
-			balance := 0
 			pubKeyHash := utils.Base58Decode([]byte(address))
 			pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-			UTXOs, err := UTXOSet.FindUTXO(pubKeyHash)
-			if err != nil {
-				log.Fatal(err)
-			}
 
-			for _, out := range UTXOs {
-				balance += out.Value
+			var balance, immature int
+			if useSPV {
+				client, err := spv.Open(networkDataDir(), chainParams())
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer client.Close()
+
+				balance, immature, err = client.GetBalance(pubKeyHash)
+				if err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer bc.CloseDB()
+				UTXOSet := utxo.UTXOSet{Blockchain: bc}
+
+				balance, immature, err = UTXOSet.GetBalance(pubKeyHash)
+				if err != nil {
+					log.Fatal(err)
+				}
 			}
 
 			fmt.Printf("Balance of '%s': %d\n", address, balance)
+			if immature > 0 {
+				fmt.Printf("Immature: %d\n", immature)
+			}
 		},
 	}
 
 	getBalanceCmd.Flags().StringVarP(&address, "address", "a", "", "The address to send genesis block reward to")
+	getBalanceCmd.Flags().BoolVar(&useSPV, "spv", false, "Use the transactions proven by spvsync instead of the full chain")
 	cobra.MarkFlagRequired(getBalanceCmd.Flags(), "address")
 
 	return getBalanceCmd
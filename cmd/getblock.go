@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"encoding/hex"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewGetBlockCommand() *cobra.Command {
+	var height int
+	var hash string
+
+	var getBlockCmd = &cobra.Command{
+		Use:   "getblock",
+		Short: "--height HEIGHT | --hash HASH - print a block of the main chain",
+		Long:  "--height HEIGHT | --hash HASH - print a block of the main chain",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			var block *blockchain.Block
+			if len(hash) > 0 {
+				blockHash, err := hex.DecodeString(hash)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				block, err = bc.GetBlock(blockHash)
+				if err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				block, err = bc.GetBlockByHeight(height)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			printBlock(bc, block)
+		},
+	}
+
+	getBlockCmd.Flags().IntVarP(&height, "height", "n", 0, "The height of the block")
+	getBlockCmd.Flags().StringVarP(&hash, "hash", "H", "", "The hash of the block")
+	getBlockCmd.MarkFlagsOneRequired("height", "hash")
+	getBlockCmd.MarkFlagsMutuallyExclusive("height", "hash")
+
+	return getBlockCmd
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewGetTransactionCommand() *cobra.Command {
+	var txID string
+
+	var getTransactionCmd = &cobra.Command{
+		Use:   "gettransaction",
+		Short: "--txid TXID - print a transaction of the main chain",
+		Long:  "--txid TXID - print a transaction of the main chain",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			tx, block, confirmations, err := bc.GetTransaction(id)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println(tx)
+			fmt.Printf("Block: %x\n", block.Hash)
+			fmt.Printf("Height: %d\n", block.Height)
+			fmt.Printf("Confirmations: %d\n", confirmations)
+		},
+	}
+
+	getTransactionCmd.Flags().StringVarP(&txID, "txid", "t", "", "The id of the transaction")
+	cobra.MarkFlagRequired(getTransactionCmd.Flags(), "txid")
+
+	return getTransactionCmd
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewGetTxOutProofCommand() *cobra.Command {
+	var txID string
+
+	var getTxOutProofCmd = &cobra.Command{
+		Use:   "gettxoutproof",
+		Short: "--txid TXID - print a proof that a transaction is in the main chain",
+		Long:  "--txid TXID - print a hex encoded proof that a transaction is in the main chain, which verifytxoutproof checks against block headers",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			id, err := hex.DecodeString(txID)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			proof, err := bc.GetTxOutProof(id)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			proofData, err := proof.Serialize()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("%x\n", proofData)
+		},
+	}
+
+	getTxOutProofCmd.Flags().StringVarP(&txID, "txid", "t", "", "The id of the transaction")
+	cobra.MarkFlagRequired(getTxOutProofCmd.Flags(), "txid")
+
+	return getTxOutProofCmd
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/bootstrap"
+	"amdzy/gochain/pkg/utxo"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func NewImportChainCommand() *cobra.Command {
+	var in string
+
+	var importChainCmd = &cobra.Command{
+		Use:   "importchain",
+		Short: "Import blocks from a bootstrap file",
+		Long:  "Import blocks from a bootstrap file, validating each one. A new chain is created if none exists yet",
+		Run: func(cmd *cobra.Command, args []string) {
+			p := chainParams()
+
+			file, err := os.Open(in)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+
+			reader, err := bootstrap.NewReader(file, p)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			genesis, err := reader.ReadBlock()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			bc, err := blockchain.NewBlockchain(networkDataDir(), p)
+			if errors.Is(err, blockchain.ErrNoBlockchain) {
+				bc, err = blockchain.CreateBlockChainWithGenesis(networkDataDir(), genesis, p)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				err = utxo.UTXOSet{Blockchain: bc}.ReIndex()
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			ourGenesis, err := bc.GetBlockByHeight(0)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if !bytes.Equal(ourGenesis.Hash, genesis.Hash) {
+				log.Fatalf("bootstrap file starts at genesis %x, the local chain at %x", genesis.Hash, ourGenesis.Hash)
+			}
+
+			bc.AddIndex(utxo.UTXOSet{Blockchain: bc})
+
+			lastHeight := 0
+			err = bootstrap.Import(bc, reader, func(height int) {
+				lastHeight = height
+				if height%progressInterval == 0 {
+					fmt.Printf("Imported block %d\n", height)
+				}
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Done! Imported up to height %d\n", lastHeight)
+		},
+	}
+
+	importChainCmd.Flags().StringVarP(&in, "in", "i", "", "Bootstrap file to read the chain from")
+	cobra.MarkFlagRequired(importChainCmd.Flags(), "in")
+
+	return importChainCmd
+}
@@ -9,12 +9,13 @@ import (
 )
 
 func NewListAddressesCommand() *cobra.Command {
+	var showPubKeys bool
 	var listAddressesCmd = &cobra.Command{
 		Use:   "listaddresses",
 		Short: "List addresses",
 		Long:  "List addresses",
 		Run: func(cmd *cobra.Command, args []string) {
-			wallets, err := wallet.NewWallets()
+			wallets, err := wallet.NewWallets(networkDataDir(), chainParams().AddressVersion)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -22,10 +23,21 @@ func NewListAddressesCommand() *cobra.Command {
 			addresses := wallets.GetAddresses()
 
 			for _, address := range addresses {
-				fmt.Println(address)
+				if !showPubKeys {
+					fmt.Println(address)
+					continue
+				}
+
+				w, err := wallets.GetWallet(address)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Printf("%s %x\n", address, w.PublicKey)
 			}
 		},
 	}
 
+	listAddressesCmd.Flags().BoolVar(&showPubKeys, "pubkeys", false, "Also print the public key of each address")
+
 	return listAddressesCmd
 }
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/utxo"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func NewLoadTxOutSetCommand() *cobra.Command {
+	var in string
+
+	var loadTxOutSetCmd = &cobra.Command{
+		Use:   "loadtxoutset",
+		Short: "Start a new chain from a UTXO set snapshot",
+		Long:  "Start a new chain from a trusted UTXO set snapshot. startnode validates the blocks below it in the background",
+		Run: func(cmd *cobra.Command, args []string) {
+			file, err := os.Open(in)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+
+			bc, metadata, err := utxo.LoadSnapshot(networkDataDir(), file, chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			fmt.Printf("Done! Loaded %d transactions at height %d, block %x\n", metadata.Transactions, metadata.Height, metadata.BlockHash)
+		},
+	}
+
+	loadTxOutSetCmd.Flags().StringVarP(&in, "in", "i", "", "Snapshot file to load")
+	cobra.MarkFlagRequired(loadTxOutSetCmd.Flags(), "in")
+
+	return loadTxOutSetCmd
+}
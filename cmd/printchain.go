@@ -14,7 +14,7 @@ func NewPrintChainCommand() *cobra.Command {
 		Short: "print all the blocks of the blockchain",
 		Long:  "print all the blocks of the blockchain",
 		Run: func(cmd *cobra.Command, args []string) {
-			bc, err := blockchain.NewBlockchain()
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -28,15 +28,7 @@ func NewPrintChainCommand() *cobra.Command {
 					log.Fatal("failed to get block")
 				}
 
-				fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
-				fmt.Printf("Hash: %x\n", block.Hash)
-				pow := blockchain.NewProofOfWork(block)
-				validPow, _ := pow.Validate()
-				fmt.Printf("Valid: %t\n", validPow)
-				for _, tx := range block.Transactions {
-					fmt.Println(tx)
-				}
-				fmt.Println()
+				printBlock(bc, block)
 
 				if len(block.PrevBlockHash) == 0 {
 					break
@@ -47,3 +39,18 @@ func NewPrintChainCommand() *cobra.Command {
 
 	return printChainCmd
 }
+
+func printBlock(bc *blockchain.Blockchain, block *blockchain.Block) {
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. hash: %x\n", block.PrevBlockHash)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Bits: %d\n", block.Bits)
+	fmt.Printf("Valid: %t\n", bc.Engine.VerifySeal(bc, &block.BlockHeader, block.Height) == nil)
+	if block.IsPruned() {
+		fmt.Println("Transactions: pruned")
+	}
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewReIndexFiltersCommand() *cobra.Command {
+	var reIndexFiltersCmd = &cobra.Command{
+		Use:   "reindexfilters",
+		Short: "rebuild the compact block filter index",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			err = bc.ReIndexFilters()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println("Done! Filter index rebuilt.")
+		},
+	}
+
+	return reIndexFiltersCmd
+}
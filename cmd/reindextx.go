@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewReIndexTxCommand() *cobra.Command {
+	var drop bool
+
+	var reIndexTxCmd = &cobra.Command{
+		Use:   "reindextx",
+		Short: "build the transaction index",
+		Long:  "build the transaction index, or remove it with --drop",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			if drop {
+				err = bc.DropTxIndex()
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				fmt.Println("Transaction index removed.")
+				return
+			}
+
+			err = bc.ReIndexTransactions()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println("Done! Transaction index rebuilt.")
+		},
+	}
+
+	reIndexTxCmd.Flags().BoolVar(&drop, "drop", false, "Remove the transaction index")
+
+	return reIndexTxCmd
+}
@@ -15,7 +15,7 @@ func NewReIndexUTXoCommand() *cobra.Command {
 		Short: "re-index UTXOs",
 		Long:  "re-index UTXOs",
 		Run: func(cmd *cobra.Command, args []string) {
-			bc, err := blockchain.NewBlockchain()
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -1,9 +1,105 @@
 package cmd
 
 import (
+	"amdzy/gochain/pkg/datadir"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/wallet"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
 	"github.com/spf13/cobra"
 )
 
+var dataDir string
+var network string
+var authorities []string
+var checkpoints []string
+var genesisHash string
+
+func chainParams() *params.ChainParams {
+	p, err := params.ByName(network)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(authorities) > 0 {
+		if !p.ProofOfAuthority {
+			log.Fatalf("network %s does not use proof of authority", p.Name)
+		}
+
+		p.Authorities = nil
+		for _, authority := range authorities {
+			pubKey, err := hex.DecodeString(authority)
+			if err == nil {
+				_, err = wallet.DecodePublicKey(pubKey)
+			}
+			if err != nil {
+				log.Fatalf("invalid authority public key %q: %v", authority, err)
+			}
+			p.Authorities = append(p.Authorities, pubKey)
+		}
+	}
+
+	if len(checkpoints) > 0 {
+		p.Checkpoints = nil
+		for _, checkpoint := range checkpoints {
+			c, err := parseCheckpoint(checkpoint)
+			if err != nil {
+				log.Fatalf("invalid checkpoint %q: %v", checkpoint, err)
+			}
+			p.Checkpoints = append(p.Checkpoints, c)
+		}
+
+		slices.SortFunc(p.Checkpoints, func(a, b params.Checkpoint) int {
+			return a.Height - b.Height
+		})
+	}
+
+	if genesisHash != "" {
+		hash, err := hex.DecodeString(genesisHash)
+		if err != nil || len(hash) != sha256.Size {
+			log.Fatalf("invalid genesis block hash %q", genesisHash)
+		}
+		p.GenesisHash = hex.EncodeToString(hash)
+	}
+
+	return p
+}
+
+// parseCheckpoint parses a checkpoint given as HEIGHT:HASH.
+func parseCheckpoint(s string) (params.Checkpoint, error) {
+	heightStr, hash, ok := strings.Cut(s, ":")
+	if !ok {
+		return params.Checkpoint{}, fmt.Errorf("want HEIGHT:HASH")
+	}
+
+	height, err := strconv.Atoi(heightStr)
+	if err != nil || height < 0 {
+		return params.Checkpoint{}, fmt.Errorf("bad height %q", heightStr)
+	}
+
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != sha256.Size {
+		return params.Checkpoint{}, fmt.Errorf("bad block hash %q", hash)
+	}
+
+	return params.Checkpoint{Height: height, Hash: hex.EncodeToString(hashBytes)}, nil
+}
+
+func networkDataDir() string {
+	dir, err := datadir.NetworkDir(dataDir, chainParams().Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return dir
+}
+
 func NewDefaultCommand() *cobra.Command {
 	var rootCmd = &cobra.Command{
 		Use:     "gochain",
@@ -15,16 +111,33 @@ func NewDefaultCommand() *cobra.Command {
 		},
 	}
 
+	rootCmd.PersistentFlags().StringVar(&dataDir, "datadir", datadir.DefaultDir(), "Directory to store the blockchain and wallets in")
+	rootCmd.PersistentFlags().StringVar(&network, "network", params.MainNet.Name, "Network to use: mainnet, testnet, regtest or poatest")
+	rootCmd.PersistentFlags().StringSliceVar(&authorities, "authorities", nil, "Hex public keys allowed to sign blocks, in turn, on a proof of authority network")
+	rootCmd.PersistentFlags().StringSliceVar(&checkpoints, "checkpoints", nil, "Main chain blocks, as HEIGHT:HASH, every block must agree with, replacing the network's checkpoints")
+	rootCmd.PersistentFlags().StringVar(&genesisHash, "genesis", "", "Hash of the network's genesis block, which light clients only accept a chain starting from")
+
 	cobra.EnableCommandSorting = false
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
 	rootCmd.AddCommand(NewPrintChainCommand())
+	rootCmd.AddCommand(NewGetBlockCommand())
 	rootCmd.AddCommand(NewCreateBlockchainCommand())
 	rootCmd.AddCommand(NewGetBalanceCommand())
 	rootCmd.AddCommand(NewSendCmdCommand())
 	rootCmd.AddCommand(NewCreateWalletCommand())
 	rootCmd.AddCommand(NewListAddressesCommand())
 	rootCmd.AddCommand(NewReIndexUTXoCommand())
+	rootCmd.AddCommand(NewReIndexTxCommand())
+	rootCmd.AddCommand(NewReIndexFiltersCommand())
+	rootCmd.AddCommand(NewGetTransactionCommand())
+	rootCmd.AddCommand(NewGetTxOutProofCommand())
+	rootCmd.AddCommand(NewVerifyTxOutProofCommand())
+	rootCmd.AddCommand(NewExportChainCommand())
+	rootCmd.AddCommand(NewImportChainCommand())
+	rootCmd.AddCommand(NewDumpTxOutSetCommand())
+	rootCmd.AddCommand(NewLoadTxOutSetCommand())
+	rootCmd.AddCommand(NewSPVSyncCommand())
 	rootCmd.AddCommand(NewStartNodeCommand())
 
 	return rootCmd
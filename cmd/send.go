@@ -16,11 +16,12 @@ func NewSendCmdCommand() *cobra.Command {
 	var sendFrom string
 	var sendTo string
 	var sendAmount int
+	var sendFee int
 
 	var sendCmd = &cobra.Command{
 		Use:   "send",
-		Short: "--from FROM --to TO --amount AMOUNT - send coins to another address",
-		Long:  "--from FROM --to TO --amount AMOUNT - send coins to another address",
+		Short: "--from FROM --to TO --amount AMOUNT [--fee FEE] - send coins to another address",
+		Long:  "--from FROM --to TO --amount AMOUNT [--fee FEE] - send coins to another address",
 		Run: func(cmd *cobra.Command, args []string) {
 			if sendAmount <= 0 {
 				fmt.Println("Amount can't be less than 0")
@@ -28,14 +29,29 @@ func NewSendCmdCommand() *cobra.Command {
 				os.Exit(1)
 			}
 
-			bc, err := blockchain.NewBlockchain()
+			if sendFee < 0 {
+				fmt.Println("Fee can't be less than 0")
+				cmd.Help()
+				os.Exit(1)
+			}
+
+			p := chainParams()
+			if !wallet.ValidateAddress(sendFrom, p.AddressVersion) {
+				log.Fatal("Invalid sender address")
+			}
+			if !wallet.ValidateAddress(sendTo, p.AddressVersion) {
+				log.Fatal("Invalid recipient address")
+			}
+
+			bc, err := blockchain.NewBlockchain(networkDataDir(), p)
 			if err != nil {
 				log.Fatal(err)
 			}
 			UTXOSet := utxo.UTXOSet{Blockchain: bc}
+			bc.AddIndex(UTXOSet)
 			defer bc.CloseDB()
 
-			wallets, err := wallet.NewWallets()
+			wallets, err := wallet.NewWallets(networkDataDir(), p.AddressVersion)
 			if err != nil {
 				log.Panic(err)
 			}
@@ -45,11 +61,12 @@ func NewSendCmdCommand() *cobra.Command {
 				log.Panic(err)
 			}
 
-			tx, err := utxo.NewUTXOTransaction(&wallet, sendTo, sendAmount, &UTXOSet)
+			tx, err := utxo.NewUTXOTransaction(&wallet, sendTo, sendAmount, sendFee, &UTXOSet)
 			if err != nil {
 				log.Fatal(err)
 			}
 
+			server.UseNetwork(p)
 			server.SendTx(server.KnownNodes[0], tx)
 
 			fmt.Println("Success!")
@@ -59,6 +76,7 @@ func NewSendCmdCommand() *cobra.Command {
 	sendCmd.Flags().StringVarP(&sendFrom, "from", "f", "", "The address to of the user sending")
 	sendCmd.Flags().StringVarP(&sendTo, "to", "t", "", "The address to of the user receiving")
 	sendCmd.Flags().IntVarP(&sendAmount, "amount", "a", 0, "The amount to send")
+	sendCmd.Flags().IntVar(&sendFee, "fee", 0, "The fee paid to the miner")
 	cobra.MarkFlagRequired(sendCmd.Flags(), "from")
 	cobra.MarkFlagRequired(sendCmd.Flags(), "to")
 	cobra.MarkFlagRequired(sendCmd.Flags(), "amount")
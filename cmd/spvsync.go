@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/server"
+	"amdzy/gochain/pkg/spv"
+	"amdzy/gochain/pkg/wallet"
+	"amdzy/gochain/utils"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewSPVSyncCommand() *cobra.Command {
+	var port string
+	var peer string
+	var useFilters bool
+
+	var spvSyncCmd = &cobra.Command{
+		Use:   "spvsync",
+		Short: "Sync a light client from a full node",
+		Long:  "Download block headers from a full node, then proofs of the transactions touching the addresses of the wallet, without the full chain. The network's genesis block hash has to be given with --genesis. See getbalance --spv",
+		Run: func(cmd *cobra.Command, args []string) {
+			p := chainParams()
+
+			if peer == "" {
+				peer = "localhost:" + p.DefaultPort
+			}
+
+			wallets, err := wallet.NewWallets(networkDataDir(), p.AddressVersion)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			var pubKeyHashes [][]byte
+			for _, address := range wallets.GetAddresses() {
+				pubKeyHash := utils.Base58Decode([]byte(address))
+				pubKeyHashes = append(pubKeyHashes, pubKeyHash[1:len(pubKeyHash)-4])
+			}
+
+			client, err := spv.Open(networkDataDir(), p)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer client.Close()
+
+			proven, err := server.SyncLightClient(client, port, peer, pubKeyHashes, useFilters)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			tip, err := client.Tip()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Synced headers to height %d, block %x\n", tip.Height, tip.Hash)
+			fmt.Printf("Verified %d transactions for %d addresses\n", proven, len(pubKeyHashes))
+		},
+	}
+
+	spvSyncCmd.Flags().StringVarP(&port, "port", "p", "", "Port to receive replies from the full node on")
+	spvSyncCmd.Flags().StringVar(&peer, "peer", "", "Address of the full node, defaults to localhost on the network's port")
+	spvSyncCmd.Flags().BoolVar(&useFilters, "filters", false, "Match compact block filters locally instead of sending the addresses to the full node")
+	cobra.MarkFlagRequired(spvSyncCmd.Flags(), "port")
+
+	return spvSyncCmd
+}
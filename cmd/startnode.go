@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"amdzy/gochain/pkg/blockchain"
 	"amdzy/gochain/pkg/server"
 	"amdzy/gochain/pkg/wallet"
 	"fmt"
 	"log"
+	"runtime"
 
 	"github.com/spf13/cobra"
 )
@@ -12,13 +14,18 @@ import (
 func NewStartNodeCommand() *cobra.Command {
 
 	var minerAddress string
+	var port string
+	var threads int
+	var pruneDepth int
 	var startNodeCmd = &cobra.Command{
 		Use:   "startnode",
 		Short: "Start Node",
 		Long:  "Start Node",
 		Run: func(cmd *cobra.Command, args []string) {
+			p := chainParams()
+
 			if len(minerAddress) > 0 {
-				validAddr := wallet.ValidateAddress(minerAddress)
+				validAddr := wallet.ValidateAddress(minerAddress, p.AddressVersion)
 				if !validAddr {
 					log.Fatal("Wrong miner address!")
 				} else {
@@ -26,7 +33,15 @@ func NewStartNodeCommand() *cobra.Command {
 				}
 			}
 
-			err := server.StartServer("6000", minerAddress)
+			if pruneDepth != 0 && pruneDepth < blockchain.MinPruneDepth {
+				log.Fatalf("--prune must be at least %d", blockchain.MinPruneDepth)
+			}
+
+			if port == "" {
+				port = p.DefaultPort
+			}
+
+			err := server.StartServer(networkDataDir(), port, minerAddress, threads, pruneDepth, p)
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -34,6 +49,9 @@ func NewStartNodeCommand() *cobra.Command {
 	}
 
 	startNodeCmd.Flags().StringVarP(&minerAddress, "miner", "a", "", "Enable mining mode and send reward to ADDRESS")
+	startNodeCmd.Flags().IntVarP(&threads, "threads", "t", runtime.NumCPU(), "Number of mining threads")
+	startNodeCmd.Flags().IntVar(&pruneDepth, "prune", 0, "Discard transactions of blocks more than DEPTH blocks below the tip")
+	startNodeCmd.Flags().StringVarP(&port, "port", "p", "", "Port to listen on, defaults to the network's port")
 
 	return startNodeCmd
 }
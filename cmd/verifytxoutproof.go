@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyTxOutProofCommand() *cobra.Command {
+	var proofHex string
+
+	var verifyTxOutProofCmd = &cobra.Command{
+		Use:   "verifytxoutproof",
+		Short: "--proof PROOF - check a proof made by gettxoutproof",
+		Long:  "--proof PROOF - check that a proof made by gettxoutproof commits to its transaction and that its block is in the main chain",
+		Run: func(cmd *cobra.Command, args []string) {
+			bc, err := blockchain.NewBlockchain(networkDataDir(), chainParams())
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer bc.CloseDB()
+
+			proofData, err := hex.DecodeString(proofHex)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			proof, err := blockchain.DeserializeTxOutProof(proofData)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			confirmations, err := bc.VerifyTxOutProof(proof)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println(proof.Transaction)
+			fmt.Printf("Block: %x\n", proof.Header.Hash())
+			fmt.Printf("Height: %d\n", proof.Height)
+			fmt.Printf("Confirmations: %d\n", confirmations)
+		},
+	}
+
+	verifyTxOutProofCmd.Flags().StringVarP(&proofHex, "proof", "p", "", "The hex encoded proof")
+	cobra.MarkFlagRequired(verifyTxOutProofCmd.Flags(), "proof")
+
+	return verifyTxOutProofCmd
+}
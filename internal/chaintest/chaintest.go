@@ -0,0 +1,133 @@
+// Package chaintest builds regtest chains in memory for tests.
+package chaintest
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"amdzy/gochain/pkg/wallet"
+	"context"
+	"encoding/hex"
+	"testing"
+)
+
+// Chain is a regtest chain whose rewards all go to Wallet. Blocks holds the
+// blocks mined by Extend, starting with genesis.
+type Chain struct {
+	t       testing.TB
+	BC      *blockchain.Blockchain
+	Wallet  *wallet.Wallet
+	Address string
+	Blocks  []*blockchain.Block
+}
+
+// New starts a chain in memory with its own copy of the regtest params, so
+// a test may change them.
+func New(t testing.TB) *Chain {
+	w, err := wallet.NewWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := w.GetAddress(params.RegTest.AddressVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := params.RegTest
+	bc, err := blockchain.CreateBlockChainInStore(storage.NewMemory(), string(address), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis, err := bc.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Chain{t: t, BC: bc, Wallet: w, Address: string(address), Blocks: []*blockchain.Block{genesis}}
+}
+
+// Extend mines n blocks on the last of Blocks.
+func (c *Chain) Extend(n int) *Chain {
+	for i := 0; i < n; i++ {
+		c.Blocks = append(c.Blocks, c.Mine(c.Blocks[len(c.Blocks)-1]))
+	}
+
+	return c
+}
+
+// Block seals txs and a coinbase on top of parent, a second after it.
+func (c *Chain) Block(parent *blockchain.Block, txs ...*transactions.Transaction) *blockchain.Block {
+	return c.BlockAt(parent, parent.Timestamp+1, txs...)
+}
+
+func (c *Chain) BlockAt(parent *blockchain.Block, timestamp int64, txs ...*transactions.Transaction) *blockchain.Block {
+	coinbase, err := transactions.NewCoinbaseTX(c.Address, "", c.BC.Params.BlockSubsidy(parent.Height+1))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	block, err := blockchain.NewBlock(context.Background(), c.BC.Engine, c.BC, append(txs, coinbase), parent.Hash, parent.Height+1, timestamp)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return block
+}
+
+// Add adds block to the chain, failing the test if it is rejected.
+func (c *Chain) Add(block *blockchain.Block) *blockchain.Block {
+	err := c.BC.AddBlock(block)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return block
+}
+
+func (c *Chain) Mine(parent *blockchain.Block, txs ...*transactions.Transaction) *blockchain.Block {
+	return c.Add(c.Block(parent, txs...))
+}
+
+func (c *Chain) Tip() *blockchain.Block {
+	hash, _, err := c.BC.Db.GetLastHashAndHeight()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	block, err := c.BC.GetBlock(hash)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return block
+}
+
+// Spend sends the first output of prev back to the wallet, less fee.
+func (c *Chain) Spend(prev *transactions.Transaction, fee int) *transactions.Transaction {
+	tx := &transactions.Transaction{
+		Vin:  []transactions.TXInput{{Txid: prev.ID, Vout: 0}},
+		Vout: []transactions.TXOutput{*transactions.NewTXOutput(prev.Vout[0].Value-fee, c.Address)},
+	}
+
+	err := tx.Sign(c.Wallet.PrivateKey, map[string]transactions.Transaction{hex.EncodeToString(prev.ID): *prev})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return tx
+}
+
+// Headers returns copies of blocks without their transactions.
+func Headers(blocks ...*blockchain.Block) []*blockchain.Block {
+	var headers []*blockchain.Block
+	for _, block := range blocks {
+		header := *block
+		header.Transactions = nil
+		headers = append(headers, &header)
+	}
+
+	return headers
+}
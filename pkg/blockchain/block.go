@@ -1,20 +1,24 @@
 package blockchain
 
 import (
+	"amdzy/gochain/pkg/consensus"
 	"amdzy/gochain/pkg/merkle"
 	"amdzy/gochain/pkg/transactions"
+	"context"
 	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+const blockVersion = 1
+
+type BlockHeader = consensus.Header
+
 type Block struct {
-	Timestamp     int64
-	Transactions  []*transactions.Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        int
+	BlockHeader
+	Transactions []*transactions.Transaction
+	Hash         []byte
+	Height       int
 }
 
 func (block *Block) Serialize() ([]byte, error) {
@@ -23,6 +27,12 @@ func (block *Block) Serialize() ([]byte, error) {
 	return b, err
 }
 
+// IsPruned reports whether the block's transactions have been discarded.
+// Every block has a coinbase, so only pruned blocks have none.
+func (block *Block) IsPruned() bool {
+	return len(block.Transactions) == 0
+}
+
 func (block *Block) HashTransactions() ([]byte, error) {
 	var transactions [][]byte
 
@@ -34,7 +44,14 @@ func (block *Block) HashTransactions() ([]byte, error) {
 
 		transactions = append(transactions, txSerialized)
 	}
-	mTree := merkle.NewMerkleTree(transactions)
+	mTree, err := merkle.NewMerkleTree(transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	if mTree.Mutated {
+		return nil, merkle.ErrMutated
+	}
 
 	return mTree.RootNode.Data, nil
 }
@@ -46,26 +63,38 @@ func DeserializeBlock(b []byte) (*Block, error) {
 	return &block, err
 }
 
-func NewBlock(transactions []*transactions.Transaction, prevHash []byte, height int) (*Block, error) {
+// NewBlock assembles a block on top of prevHash and has engine seal it.
+func NewBlock(ctx context.Context, engine consensus.Engine, chain consensus.ChainReader, transactions []*transactions.Transaction, prevHash []byte, height int, timestamp int64) (*Block, error) {
 	block := &Block{
-		Transactions:  transactions,
-		PrevBlockHash: prevHash,
-		Timestamp:     time.Now().UTC().Unix(),
-		Height:        height,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevHash,
+			Timestamp:     timestamp,
+		},
+		Transactions: transactions,
+		Height:       height,
+	}
+
+	merkleRoot, err := block.HashTransactions()
+	if err != nil {
+		return nil, err
 	}
+	block.MerkleRoot = merkleRoot
 
-	pow := NewProofOfWork(block)
-	nonce, hash, err := pow.Run()
+	err = engine.Prepare(chain, &block.BlockHeader, height)
 	if err != nil {
 		return nil, err
 	}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+	hash, err := engine.Seal(ctx, chain, &block.BlockHeader, height)
+	if err != nil {
+		return nil, err
+	}
+	block.Hash = hash
 
 	return block, nil
 }
 
-func NewGenesisBlock(coinbase *transactions.Transaction) (*Block, error) {
-	return NewBlock([]*transactions.Transaction{coinbase}, []byte{}, 0)
+func NewGenesisBlock(engine consensus.Engine, coinbase *transactions.Transaction) (*Block, error) {
+	return NewBlock(context.Background(), engine, nil, []*transactions.Transaction{coinbase}, []byte{}, 0, time.Now().UTC().Unix())
 }
@@ -1,22 +1,47 @@
 package blockchain
 
 import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
 	"amdzy/gochain/pkg/transactions"
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
-
-	bolt "go.etcd.io/bbolt"
+	"sync"
 )
 
 type Blockchain struct {
-	Db  *DB
-	tip []byte
+	Db         *DB
+	Params     *params.ChainParams
+	TimeSource TimeSource
+	Engine     consensus.Engine
+	// PruneDepth, when non-zero, drops the transactions of blocks more
+	// than PruneDepth blocks below the tip.
+	PruneDepth int
+	indexes    []ChainIndex
+
+	// addMu serializes AddBlock, tipMu guards tip, which is read by any
+	// goroutine.
+	addMu sync.Mutex
+	tipMu sync.RWMutex
+	tip   []byte
+}
+
+func (bc *Blockchain) currentTip() []byte {
+	bc.tipMu.RLock()
+	defer bc.tipMu.RUnlock()
+
+	return bc.tip
 }
 
-func (bc *Blockchain) MineBlock(transactions []*transactions.Transaction) (*Block, error) {
+// MineBlock builds a block on top of the current tip and searches for its
+// seal through the consensus engine. Sealing stops early with ctx.Err() when ctx is cancelled,
+// e.g. because another node extended the chain first.
+func (bc *Blockchain) MineBlock(ctx context.Context, transactions []*transactions.Transaction) (*Block, error) {
 	lastHash, lastHeight, err := bc.Db.GetLastHashAndHeight()
 	if err != nil {
 		return nil, err
@@ -33,84 +58,83 @@ func (bc *Blockchain) MineBlock(transactions []*transactions.Transaction) (*Bloc
 		}
 	}
 
-	block, err := NewBlock(transactions, lastHash, lastHeight+1)
+	medianTime, err := bc.CalcPastMedianTime(lastHash)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bc.Db.AddBlock(block)
+	timestamp := max(bc.adjustedTime().Unix(), medianTime+1)
+
+	block, err := NewBlock(ctx, bc.Engine, bc, transactions, lastHash, lastHeight+1, timestamp)
 	if err != nil {
 		return nil, err
 	}
 
-	bc.tip = block.Hash
+	err = bc.AddBlock(block)
+	if err != nil {
+		return nil, err
+	}
 
 	return block, nil
 }
 
 func (bc *Blockchain) Iterator() *BlockchainIterator {
-	bci := &BlockchainIterator{bc.tip, bc.Db}
+	bci := &BlockchainIterator{bc.currentTip(), bc.Db}
 
 	return bci
 }
 
-func (bc *Blockchain) FindUTXO() (map[string]transactions.TXOutputs, error) {
-	UTXO := make(map[string]transactions.TXOutputs)
-	spentTXOs := make(map[string][]int)
-	bci := bc.Iterator()
+func (bc *Blockchain) ForwardIterator(height int) *BlockchainForwardIterator {
+	return &BlockchainForwardIterator{height, bc.Db}
+}
 
-	for {
-		block, err := bci.Next()
+func (bc *Blockchain) FindTransaction(id []byte) (*transactions.Transaction, error) {
+	tx, _, err := bc.findTransaction(bc.currentTip(), id)
+
+	return tx, err
+}
+
+func (bc *Blockchain) GetTransaction(id []byte) (*transactions.Transaction, *Block, int, error) {
+	tx, block, err := bc.findTransaction(bc.currentTip(), id)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return tx, block, bestHeight - block.Height + 1, nil
+}
+
+func (bc *Blockchain) findTransaction(from, id []byte) (*transactions.Transaction, *Block, error) {
+	if bytes.Equal(from, bc.currentTip()) {
+		tx, block, indexed, err := bc.lookupTransaction(id)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				if spentTXOs[txID] != nil {
-					for _, spentOutIdx := range spentTXOs[txID] {
-						if spentOutIdx == outIdx {
-							continue Outputs
-						}
-					}
-				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
+		if indexed {
+			if tx == nil {
+				return nil, nil, ErrTransactionNotFound
 			}
 
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Vin {
-					inTxID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
-				}
-			}
-		}
-
-		if len(block.PrevBlockHash) == 0 {
-			break
+			return tx, block, nil
 		}
 	}
 
-	return UTXO, nil
-}
-
-func (bc *Blockchain) FindTransaction(id []byte) (*transactions.Transaction, error) {
-	bci := bc.Iterator()
+	bci := &BlockchainIterator{from, bc.Db}
 
 	for {
 		block, err := bci.Next()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Equal(id, tx.ID) {
-				return tx, nil
+				return tx, block, nil
 			}
 		}
 
@@ -119,18 +143,13 @@ func (bc *Blockchain) FindTransaction(id []byte) (*transactions.Transaction, err
 		}
 	}
 
-	return nil, fmt.Errorf("transaction not found")
+	return nil, nil, ErrTransactionNotFound
 }
 
 func (bc *Blockchain) SignTransaction(tx *transactions.Transaction, privKey ecdsa.PrivateKey) error {
-	prevTXs := make(map[string]transactions.Transaction)
-
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			return err
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+	prevTXs, _, err := bc.findPrevTransactions(bc.currentTip(), tx)
+	if err != nil {
+		return err
 	}
 
 	return tx.Sign(privKey, prevTXs)
@@ -141,55 +160,194 @@ func (bc *Blockchain) VerifyTransaction(tx *transactions.Transaction) (bool, err
 		return true, nil
 	}
 
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return false, err
+	}
+
+	view := bc.utxoView()
+	if view == nil {
+		_, err := bc.validateTransaction(bc.ancestorOutputs(bc.currentTip()), bestHeight+1, tx, true)
+
+		return err == nil, nil
+	}
+
+	var valid bool
+
+	err = bc.Db.View(func(dbTx storage.Tx) error {
+		_, err := bc.validateTransaction(func(txID []byte) (*transactions.TXOutputs, error) {
+			return view.FetchOutputs(dbTx, txID)
+		}, bestHeight+1, tx, true)
+		valid = err == nil
+
+		return nil
+	})
+
+	return valid, err
+}
+
+func (bc *Blockchain) CalculateFee(tx *transactions.Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	prevTXs, _, err := bc.findPrevTransactions(bc.currentTip(), tx)
+	if err != nil {
+		return 0, err
+	}
+
+	return tx.Fee(prevTXs, bc.Params.MaxMoney)
+}
+
+func (bc *Blockchain) findPrevTransactions(from []byte, tx *transactions.Transaction) (map[string]transactions.Transaction, map[string]int, error) {
 	prevTXs := make(map[string]transactions.Transaction)
+	prevHeights := make(map[string]int)
 
 	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
+		prevTX, block, err := bc.findTransaction(from, vin.Txid)
+		if errors.Is(err, ErrTransactionNotFound) {
+			var height int
+			prevTX, height, err = bc.findPrunedTransaction(vin.Txid)
+			if err != nil {
+				return nil, nil, err
+			}
+			block = &Block{Height: height}
+		}
 		if err != nil {
-			return false, nil
+			return nil, nil, err
 		}
-		prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+
+		txID := hex.EncodeToString(prevTX.ID)
+		prevTXs[txID] = *prevTX
+		prevHeights[txID] = block.Height
 	}
 
-	return tx.Verify(prevTXs)
+	return prevTXs, prevHeights, nil
 }
 
-func (bc *Blockchain) AddBlock(block *Block) error {
-	err := bc.Db.Db.Update(func(tx *bolt.Tx) error {
+func (bc *Blockchain) checkCoinbaseMaturity(tx *transactions.Transaction, prevTXs map[string]transactions.Transaction, prevHeights map[string]int, spendHeight int) error {
+	for _, vin := range tx.Vin {
+		txID := hex.EncodeToString(vin.Txid)
+		prevTX := prevTXs[txID]
+
+		if prevTX.IsCoinbase() && spendHeight-prevHeights[txID] < bc.Params.CoinbaseMaturity {
+			return fmt.Errorf("%w: %x", ErrImmatureSpend, vin.Txid)
+		}
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) HasBlock(blockHash []byte) (bool, error) {
+	var found bool
+
+	err := bc.Db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+		found = b.Get(blockHash) != nil
 
-		if blockInDb != nil {
-			return nil
+		return nil
+	})
+
+	return found, err
+}
+
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.addMu.Lock()
+	defer bc.addMu.Unlock()
+
+	known, err := bc.HasBlock(block.Hash)
+	if err != nil {
+		return err
+	}
+
+	if known {
+		return nil
+	}
+
+	err = bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	var newTip []byte
+	var detached int
+
+	err = bc.Db.Update(func(tx storage.Tx) error {
+		err := putBlock(tx, block)
+		if err != nil {
+			return err
 		}
 
-		blockData, err := block.Serialize()
+		work, err := getChainWork(tx, block.Hash)
 		if err != nil {
 			return err
 		}
 
-		err = b.Put(block.Hash, blockData)
+		lastHash := tx.Bucket([]byte(blocksBucket)).Get([]byte("l"))
+		lastWork, err := getChainWork(tx, lastHash)
 		if err != nil {
 			return err
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock, err := DeserializeBlock(lastBlockData)
+		heavier := work.Cmp(lastWork) > 0
+		if !heavier && bc.utxoView() == nil {
+			return nil
+		}
+
+		// Connecting the block checks its transactions against the outputs
+		// its parent leaves unspent. A block that does not become the tip is
+		// rolled back and stored on its own below, so that an invalid side
+		// branch is rejected now rather than when it outgrows the main chain.
+		detached, err = bc.reorganize(tx, lastHash, block)
 		if err != nil {
 			return err
 		}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				return err
-			}
-			bc.tip = block.Hash
+		if !heavier {
+			return errSideBranch
 		}
+		newTip = block.Hash
 
-		return nil
+		return bc.prune(tx, block.Height)
 	})
+	if errors.Is(err, errSideBranch) {
+		err = bc.Db.Update(func(tx storage.Tx) error {
+			return putBlock(tx, block)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	if newTip != nil {
+		if detached > 0 {
+			fmt.Printf("Reorganized chain: disconnected %d blocks\n", detached)
+		}
+		bc.tipMu.Lock()
+		bc.tip = newTip
+		bc.tipMu.Unlock()
+	}
+
+	return nil
+}
+
+// errSideBranch rolls back the trial connection of a block that does not
+// become the tip.
+var errSideBranch = errors.New("block is on a side branch")
+
+// putBlock stores block along with its chain work.
+func putBlock(tx storage.Tx, block *Block) error {
+	blockData, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+
+	err = tx.Bucket([]byte(blocksBucket)).Put(block.Hash, blockData)
+	if err != nil {
+		return err
+	}
+
+	_, err = getChainWork(tx, block.Hash)
 
 	return err
 }
@@ -197,7 +355,7 @@ func (bc *Blockchain) AddBlock(block *Block) error {
 func (bc *Blockchain) GetBestHeight() (int, error) {
 	var lastBlock *Block
 
-	err := bc.Db.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		lastHash := b.Get([]byte("l"))
 		blockData := b.Get(lastHash)
@@ -219,19 +377,19 @@ func (bc *Blockchain) GetBestHeight() (int, error) {
 func (bc *Blockchain) GetBlock(blockHash []byte) (*Block, error) {
 	var block *Block
 
-	err := bc.Db.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		blockData := b.Get(blockHash)
 
 		if blockData == nil {
-			return errors.New("block not found")
+			return ErrBlockNotFound
 		}
 
 		var err error
 		block, err = DeserializeBlock(blockData)
 		if err != nil {
-			return errors.New("block not found")
+			return err
 		}
 
 		return nil
@@ -240,6 +398,19 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (*Block, error) {
 	return block, err
 }
 
+func (bc *Blockchain) GetHeader(blockHash []byte) (*BlockHeader, int, error) {
+	block, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &block.BlockHeader, block.Height, nil
+}
+
+func (bc *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	return bc.Db.GetBlockByHeight(height)
+}
+
 func (bc *Blockchain) GetBlockHashes() ([][]byte, error) {
 	var blocks [][]byte
 	bci := bc.Iterator()
@@ -264,30 +435,108 @@ func (bc *Blockchain) CloseDB() {
 	bc.Db.Close()
 }
 
-func NewBlockchain() (*Blockchain, error) {
-	db, err := ConnectDB()
+func NewBlockchain(dataDir string, p *params.ChainParams) (*Blockchain, error) {
+	db, err := ConnectDB(dataDir)
 	if err != nil {
 		return nil, err
 	}
 
-	lastHash, _, err := db.GetLastHashAndHeight()
+	err = db.Update(buildHeightIndex)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newBlockchainFromDB(db, p)
+}
+
+// NewBlockchainFromStore opens a chain kept in store rather than in a data
+// directory.
+func NewBlockchainFromStore(store storage.Store, p *params.ChainParams) (*Blockchain, error) {
+	db := &DB{Store: store}
+
+	err := checkDB(db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(buildHeightIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockchainFromDB(db, p)
+}
+
+func CreateBlockChain(dataDir, address string, p *params.ChainParams) (*Blockchain, error) {
+	db, err := InitDB(dataDir, address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockchainFromDB(db, p)
+}
+
+// CreateBlockChainInStore starts a new chain in store, e.g. one returned by
+// storage.NewMemory for tests and simulations.
+func CreateBlockChainInStore(store storage.Store, address string, p *params.ChainParams) (*Blockchain, error) {
+	genesis, err := newGenesis(address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{Store: store}
+
+	err = initDB(db, genesis)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Blockchain{db, lastHash}, nil
+	return newBlockchainFromDB(db, p)
 }
 
-func CreateBlockChain(address string) (*Blockchain, error) {
-	db, err := InitDB(address)
+// CreateBlockChainWithGenesis starts a new chain from an existing genesis
+// block, e.g. one read from a bootstrap file.
+func CreateBlockChainWithGenesis(dataDir string, genesis *Block, p *params.ChainParams) (*Blockchain, error) {
+	if len(genesis.PrevBlockHash) != 0 || genesis.Height != 0 {
+		return nil, fmt.Errorf("block %x is not a genesis block", genesis.Hash)
+	}
+
+	if !bytes.Equal(genesis.BlockHeader.Hash(), genesis.Hash) {
+		return nil, fmt.Errorf("%w: hash does not match the header", ErrBadSeal)
+	}
+
+	err := consensus.New(p).VerifySeal(nil, &genesis.BlockHeader, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadSeal, err)
+	}
+
+	merkleRoot, err := genesis.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(merkleRoot, genesis.MerkleRoot) {
+		return nil, ErrBadMerkleRoot
+	}
+
+	db, err := InitDBWithGenesis(dataDir, genesis)
 	if err != nil {
 		return nil, err
 	}
 
+	return newBlockchainFromDB(db, p)
+}
+
+func newBlockchainFromDB(db *DB, p *params.ChainParams) (*Blockchain, error) {
 	lastHash, _, err := db.GetLastHashAndHeight()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Blockchain{db, lastHash}, nil
+	err = db.Update(buildFilterIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blockchain{Db: db, Params: p, Engine: consensus.New(p), tip: lastHash, indexes: []ChainIndex{heightIndex{}, txIndex{}, filterIndex{}}}, nil
 }
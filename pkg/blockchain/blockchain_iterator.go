@@ -1,5 +1,7 @@
 package blockchain
 
+import "errors"
+
 type BlockchainIterator struct {
 	currentHash []byte
 	db          *DB
@@ -15,3 +17,23 @@ func (i *BlockchainIterator) Next() (*Block, error) {
 
 	return block, nil
 }
+
+type BlockchainForwardIterator struct {
+	currentHeight int
+	db            *DB
+}
+
+// Next returns nil once the iterator has moved past the tip.
+func (i *BlockchainForwardIterator) Next() (*Block, error) {
+	block, err := i.db.GetBlockByHeight(i.currentHeight)
+	if errors.Is(err, ErrBlockNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i.currentHeight++
+
+	return block, nil
+}
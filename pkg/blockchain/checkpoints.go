@@ -0,0 +1,191 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// headersBucket holds headers received ahead of their blocks.
+	headersBucket = "headers"
+	// assumeValidBucket holds the hashes of those headers that lead up to
+	// a checkpoint.
+	assumeValidBucket = "assumevalid"
+)
+
+// checkCheckpoints rejects a block that conflicts with a checkpoint, either
+// by having a different hash at a checkpoint height or by forking off the
+// main chain below a checkpoint the chain has already passed.
+func (bc *Blockchain) checkCheckpoints(block *Block) error {
+	checkpoint, ok := bc.Params.CheckpointAt(block.Height)
+	if ok && checkpoint.Hash != hex.EncodeToString(block.Hash) {
+		return fmt.Errorf("%w: block %x at height %d, want %s", ErrCheckpointMismatch, block.Hash, block.Height, checkpoint.Hash)
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	// The main chain already contains this checkpoint, so any new block at
+	// or below it is on a competing branch.
+	passed, ok := bc.Params.LastCheckpoint(bestHeight)
+	if ok && block.Height <= passed.Height {
+		return fmt.Errorf("%w: block %x at height %d, checkpoint at %d", ErrCheckpointConflict, block.Hash, block.Height, passed.Height)
+	}
+
+	return nil
+}
+
+// AddHeaders checks headers received ahead of their blocks, which have to
+// follow each other from a block or header the chain already has, and stores
+// them. Once the headers reach a checkpoint, the blocks leading up to it are
+// known to be on the checkpointed chain, so their signatures are not verified
+// as they are connected.
+func (bc *Blockchain) AddHeaders(headers []*Block) error {
+	return bc.Db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+
+		chain := storedHeaders{tx}
+		for _, header := range headers {
+			if !header.IsPruned() {
+				return fmt.Errorf("block %x at height %d is not a header", header.Hash, header.Height)
+			}
+
+			_, _, err := chain.GetHeader(header.Hash)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, ErrBlockNotFound) {
+				return err
+			}
+
+			err = CheckHeader(chain, bc.Engine, header, bc.Params)
+			if err != nil {
+				return err
+			}
+
+			headerData, err := header.Serialize()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(header.Hash, headerData)
+			if err != nil {
+				return err
+			}
+		}
+
+		// CheckHeader rejected any header that does not match a checkpoint
+		// at its height.
+		for _, header := range headers {
+			_, ok := bc.Params.CheckpointAt(header.Height)
+			if !ok {
+				continue
+			}
+
+			err := markAssumeValid(tx, header.Hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// markAssumeValid records the stored headers from hash back to the first
+// one that is recorded already or whose block the chain has.
+func markAssumeValid(tx storage.Tx, hash []byte) error {
+	av, err := tx.CreateBucketIfNotExists([]byte(assumeValidBucket))
+	if err != nil {
+		return err
+	}
+	hb := tx.Bucket([]byte(headersBucket))
+
+	for av.Get(hash) == nil {
+		headerData := hb.Get(hash)
+		if headerData == nil {
+			return nil
+		}
+
+		header, err := DeserializeBlock(headerData)
+		if err != nil {
+			return err
+		}
+
+		err = av.Put(hash, []byte{1})
+		if err != nil {
+			return err
+		}
+
+		hash = header.PrevBlockHash
+	}
+
+	return nil
+}
+
+// storedHeaders is a ChainReader over the blocks and the headers stored
+// ahead of them.
+type storedHeaders struct {
+	tx storage.Tx
+}
+
+func (c storedHeaders) GetHeader(hash []byte) (*BlockHeader, int, error) {
+	block, err := GetBlockTx(c.tx, hash)
+	if errors.Is(err, ErrBlockNotFound) {
+		b := c.tx.Bucket([]byte(headersBucket))
+		if b == nil || b.Get(hash) == nil {
+			return nil, 0, ErrBlockNotFound
+		}
+
+		block, err = DeserializeBlock(b.Get(hash))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &block.BlockHeader, block.Height, nil
+}
+
+// AssumeValid reports whether block is known to lead up to a checkpoint, in
+// which case its signatures are not verified. That is the case for headers
+// stored by AddHeaders ahead of a checkpoint, and for main chain blocks
+// below a checkpoint that the main chain matches.
+func (bc *Blockchain) AssumeValid(block *Block) (bool, error) {
+	var valid bool
+
+	err := bc.Db.View(func(tx storage.Tx) error {
+		valid = bc.assumeValid(tx, block)
+
+		return nil
+	})
+
+	return valid, err
+}
+
+func (bc *Blockchain) assumeValid(tx storage.Tx, block *Block) bool {
+	av := tx.Bucket([]byte(assumeValidBucket))
+	if av != nil && av.Get(block.Hash) != nil {
+		return true
+	}
+
+	b := tx.Bucket([]byte(heightsBucket))
+	if b == nil || !bytes.Equal(b.Get(heightKey(block.Height)), block.Hash) {
+		return false
+	}
+
+	for _, checkpoint := range bc.Params.Checkpoints {
+		if checkpoint.Height >= block.Height && hex.EncodeToString(b.Get(heightKey(checkpoint.Height))) == checkpoint.Hash {
+			return true
+		}
+	}
+
+	return false
+}
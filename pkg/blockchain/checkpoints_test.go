@@ -0,0 +1,195 @@
+package blockchain_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/script"
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func checkpoint(block *blockchain.Block) params.Checkpoint {
+	return params.Checkpoint{Height: block.Height, Hash: hex.EncodeToString(block.Hash)}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+	c.BC.Params.Checkpoints = []params.Checkpoint{{Height: 3, Hash: hex.EncodeToString(make([]byte, 32))}}
+
+	err := c.BC.AddBlock(c.Block(c.Blocks[2]))
+	if !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrCheckpointMismatch)
+	}
+}
+
+func TestCheckpointConflict(t *testing.T) {
+	c := chaintest.New(t).Extend(4)
+	c.BC.Params.Checkpoints = []params.Checkpoint{checkpoint(c.Blocks[3])}
+
+	err := c.BC.AddBlock(c.Block(c.Blocks[1]))
+	if !errors.Is(err, blockchain.ErrCheckpointConflict) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrCheckpointConflict)
+	}
+
+	// Blocks above the checkpoint may still fork.
+	err = c.BC.AddBlock(c.Block(c.Blocks[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAssumeValid(t *testing.T) {
+	c := chaintest.New(t).Extend(5)
+	side := c.Block(c.Blocks[1])
+	err := c.BC.AddBlock(side)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		checkpoints []params.Checkpoint
+		block       *blockchain.Block
+		want        bool
+	}{
+		{"no checkpoints", nil, c.Blocks[2], false},
+		{"below checkpoint", []params.Checkpoint{checkpoint(c.Blocks[4])}, c.Blocks[2], true},
+		{"at checkpoint", []params.Checkpoint{checkpoint(c.Blocks[4])}, c.Blocks[4], true},
+		{"above checkpoint", []params.Checkpoint{checkpoint(c.Blocks[4])}, c.Blocks[5], false},
+		{"side branch", []params.Checkpoint{checkpoint(c.Blocks[4])}, side, false},
+		{"unmatched checkpoint", []params.Checkpoint{{Height: 4, Hash: hex.EncodeToString(side.Hash)}}, c.Blocks[2], false},
+		{"checkpoint above the tip", []params.Checkpoint{{Height: 9, Hash: hex.EncodeToString(c.Blocks[5].Hash)}}, c.Blocks[2], false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.BC.Params.Checkpoints = tt.checkpoints
+
+			got, err := c.BC.AssumeValid(tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// badSignature spends the genesis coinbase with a corrupt signature.
+func badSignature(t *testing.T, c *chaintest.Chain) *transactions.Transaction {
+	prev := c.Blocks[0].Transactions[0]
+	tx := c.Spend(prev, 0)
+
+	// Corrupt the signature, which follows its push opcode.
+	tx.Vin[0].ScriptSig[1] ^= 0xff
+	err := tx.SetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+// A side branch below a checkpoint the chain has not reached yet still has
+// its signatures verified.
+func TestSideBranchSignaturesBelowCheckpoint(t *testing.T) {
+	c := chaintest.New(t).Extend(3)
+	c.BC.Params.Checkpoints = []params.Checkpoint{{Height: 10, Hash: hex.EncodeToString(make([]byte, 32))}}
+
+	err := c.BC.AddBlock(c.Block(c.Blocks[1], badSignature(t, c)))
+	if !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrInvalidTransaction)
+	}
+	if !errors.Is(err, script.ErrVerifyFailed) {
+		t.Fatalf("got %v, want %v", err, script.ErrVerifyFailed)
+	}
+}
+
+// withBlock is the chain with block, which it has not accepted, on top.
+type withBlock struct {
+	*blockchain.Blockchain
+	block *blockchain.Block
+}
+
+func (c withBlock) GetHeader(hash []byte) (*blockchain.BlockHeader, int, error) {
+	if bytes.Equal(hash, c.block.Hash) {
+		return &c.block.BlockHeader, c.block.Height, nil
+	}
+
+	return c.Blockchain.GetHeader(hash)
+}
+
+// blockOn mines a block on parent, which the chain has not accepted.
+func blockOn(t *testing.T, c *chaintest.Chain, parent *blockchain.Block) *blockchain.Block {
+	coinbase, err := transactions.NewCoinbaseTX(c.Address, "", c.BC.Params.BlockSubsidy(parent.Height+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := blockchain.NewBlock(context.Background(), c.BC.Engine, withBlock{c.BC, parent}, []*transactions.Transaction{coinbase}, parent.Hash, parent.Height+1, parent.Timestamp+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// Headers received ahead of their blocks show which blocks lead up to a
+// checkpoint, so their signatures are skipped while syncing.
+func TestAssumeValidHeaders(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+
+	bad := c.Block(c.Blocks[2], badSignature(t, c))
+
+	top := blockOn(t, c, bad)
+	c.BC.Params.Checkpoints = []params.Checkpoint{checkpoint(top)}
+
+	err := c.BC.AddBlock(bad)
+	if !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("without headers: got %v, want %v", err, blockchain.ErrInvalidTransaction)
+	}
+
+	// Headers that do not reach the checkpoint change nothing.
+	fork := c.Block(c.Blocks[2], badSignature(t, c))
+	err = c.BC.AddHeaders(chaintest.Headers(fork))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.BC.AddBlock(fork)
+	if !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("fork: got %v, want %v", err, blockchain.ErrInvalidTransaction)
+	}
+
+	err = c.BC.AddHeaders(chaintest.Headers(blockOn(t, c, fork)))
+	if !errors.Is(err, blockchain.ErrCheckpointMismatch) {
+		t.Fatalf("fork at the checkpoint: got %v, want %v", err, blockchain.ErrCheckpointMismatch)
+	}
+
+	err = c.BC.AddHeaders(chaintest.Headers(bad, top))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range []*blockchain.Block{bad, top} {
+		valid, err := c.BC.AssumeValid(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid {
+			t.Fatalf("block at height %d is not assumed valid", block.Height)
+		}
+
+		c.Add(block)
+	}
+
+	if !bytes.Equal(c.Tip().Hash, top.Hash) {
+		t.Fatalf("tip is %x, want %x", c.Tip().Hash, top.Hash)
+	}
+}
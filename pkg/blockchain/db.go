@@ -1,26 +1,34 @@
 package blockchain
 
 import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/datadir"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
 	"amdzy/gochain/pkg/transactions"
+	"errors"
 	"fmt"
-	"log"
-
-	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"time"
 )
 
+const dbFile = "blockchain.db"
 const blocksBucket = "blocks"
 
+var ErrNoBlockchain = errors.New("no existing blockchain found. Create one first")
+
 type DB struct {
-	Db *bolt.DB
+	storage.Store
+	lock *datadir.Lock
 }
 
 func (db *DB) GetLastHashAndHeight() ([]byte, int, error) {
 	var lastHash []byte
 	var lastHeight int
 
-	err := db.Db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
 		blockData := b.Get(lastHash)
 		block, err := DeserializeBlock(blockData)
@@ -36,37 +44,15 @@ func (db *DB) GetLastHashAndHeight() ([]byte, int, error) {
 	return lastHash, lastHeight, err
 }
 
-func (db *DB) AddBlock(block *Block) error {
-	err := db.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-
-		blockBytes, err := block.Serialize()
-		if err != nil {
-			return err
-		}
-
-		err = b.Put(block.Hash, blockBytes)
-		if err != nil {
-			return err
-		}
-
-		err = b.Put([]byte("l"), block.Hash)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	return err
-}
-
 func (db *DB) GetBlock(hash []byte) (*Block, error) {
 	var block *Block
 
-	err := db.Db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 		encodedBlock := b.Get(hash)
+		if encodedBlock == nil {
+			return ErrBlockNotFound
+		}
 
 		var err error
 		block, err = DeserializeBlock(encodedBlock)
@@ -81,73 +67,125 @@ func (db *DB) GetBlock(hash []byte) (*Block, error) {
 }
 
 func (db *DB) Close() {
-	db.Db.Close()
+	db.Store.Close()
+	if db.lock != nil {
+		db.lock.Release()
+	}
+}
+
+func openDB(dataDir string) (*DB, error) {
+	lock, err := datadir.AcquireLock(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.OpenBolt(filepath.Join(dataDir, dbFile), time.Second)
+	if errors.Is(err, storage.ErrTimeout) {
+		lock.Release()
+		return nil, fmt.Errorf("%w: %s", datadir.ErrLocked, dataDir)
+	}
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return &DB{Store: store, lock: lock}, nil
 }
 
-func InitDB(address string) (*DB, error) {
-	db, err := bolt.Open("blockchain.db", 0600, nil)
+func newGenesis(address string, p *params.ChainParams) (*Block, error) {
+	coinbaseTx, err := transactions.NewCoinbaseTX(address, p.GenesisCoinbaseData, p.Subsidy)
 	if err != nil {
-		log.Fatal("failed to connect to db")
+		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	return NewGenesisBlock(consensus.New(p), coinbaseTx)
+}
+
+func InitDB(dataDir, address string, p *params.ChainParams) (*DB, error) {
+	genesis, err := newGenesis(address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return InitDBWithGenesis(dataDir, genesis)
+}
+
+// InitDBWithGenesis creates a new chain database starting at genesis.
+func InitDBWithGenesis(dataDir string, genesis *Block) (*DB, error) {
+	db, err := openDB(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = initDB(db, genesis)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func initDB(db *DB, genesis *Block) error {
+	return db.Update(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b != nil {
 			return fmt.Errorf("blockchain already exists")
 		}
 
-		coinbaseTx, err := transactions.NewCoinbaseTX(address, "")
-		if err != nil {
-			return err
-		}
-
-		genesis, err := NewGenesisBlock(coinbaseTx)
+		b, err := tx.CreateBucket([]byte(blocksBucket))
 		if err != nil {
 			return err
 		}
 
-		b, err = tx.CreateBucket([]byte(blocksBucket))
+		genesisBytes, err := genesis.Serialize()
 		if err != nil {
 			return err
 		}
 
-		genesisBytes, err := genesis.Serialize()
+		err = b.Put(genesis.Hash, genesisBytes)
 		if err != nil {
 			return err
 		}
 
-		err = b.Put(genesis.Hash, genesisBytes)
+		err = b.Put([]byte("l"), genesis.Hash)
 		if err != nil {
 			return err
 		}
 
-		err = b.Put([]byte("l"), genesis.Hash)
+		_, err = getChainWork(tx, genesis.Hash)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return heightIndex{}.ConnectBlock(tx, genesis)
 	})
-
-	return &DB{Db: db}, err
 }
 
-func ConnectDB() (*DB, error) {
-	db, err := bolt.Open("blockchain.db", 0600, nil)
+func ConnectDB(dataDir string) (*DB, error) {
+	db, err := openDB(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDB(db)
 	if err != nil {
-		log.Fatal("failed to connect to db")
+		db.Close()
+		return nil, err
 	}
 
-	err = db.View(func(tx *bolt.Tx) error {
+	return db, nil
+}
+
+func checkDB(db *DB) error {
+	return db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
 
 		if b == nil {
-			return fmt.Errorf("no existing blockchain found. Create one first")
+			return ErrNoBlockchain
 		}
 
 		return nil
 	})
-
-	return &DB{Db: db}, err
 }
@@ -0,0 +1,134 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/gcs"
+	"amdzy/gochain/pkg/storage"
+	"encoding/binary"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const filterIndexBucket = "filters"
+
+// filterIndex maps block hashes to the compact filters of the blocks. It is
+// built when the chain is opened, if it was not already. Filters are kept
+// when their block is disconnected or pruned, so pruned nodes can still
+// serve them.
+type filterIndex struct{}
+
+func (filterIndex) ConnectBlock(tx storage.Tx, block *Block) error {
+	return putFilter(tx.Bucket([]byte(filterIndexBucket)), block)
+}
+
+func (filterIndex) DisconnectBlock(tx storage.Tx, block *Block) error {
+	return nil
+}
+
+func putFilter(b storage.Bucket, block *Block) error {
+	filterData, err := msgpack.Marshal(BlockFilter(block))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(block.Hash, filterData)
+}
+
+// BlockFilter builds the compact filter of block, over the ScriptPubKey of
+// every output and every outpoint spent, as encoded by FilterOutpoint.
+func BlockFilter(block *Block) *gcs.Filter {
+	var items [][]byte
+
+	for _, tx := range block.Transactions {
+		for _, out := range tx.Vout {
+			items = append(items, out.ScriptPubKey)
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			items = append(items, FilterOutpoint(vin.Txid, vin.Vout))
+		}
+	}
+
+	return gcs.Build(FilterKey(block.Hash), items)
+}
+
+// FilterKey is the key the filter of the block with the given hash is built
+// under.
+func FilterKey(blockHash []byte) []byte {
+	return blockHash[:16]
+}
+
+func FilterOutpoint(txID []byte, vout int) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, txID...), uint32(vout))
+}
+
+// GetFilter returns the filter of the block with the given hash, or nil if
+// there is none.
+func (bc *Blockchain) GetFilter(blockHash []byte) (*gcs.Filter, error) {
+	var filter *gcs.Filter
+
+	err := bc.Db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(filterIndexBucket))
+		if b == nil {
+			return nil
+		}
+
+		filterData := b.Get(blockHash)
+		if filterData == nil {
+			return nil
+		}
+
+		filter = &gcs.Filter{}
+		return msgpack.Unmarshal(filterData, filter)
+	})
+
+	return filter, err
+}
+
+// buildFilterIndex builds the filters of the main chain if there is no
+// filter index yet. Blocks that have already been pruned are left without
+// one.
+func buildFilterIndex(tx storage.Tx) error {
+	if tx.Bucket([]byte(filterIndexBucket)) != nil {
+		return nil
+	}
+
+	b, err := tx.CreateBucket([]byte(filterIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	c := tx.Bucket([]byte(heightsBucket)).Cursor()
+	for _, hash := c.First(); hash != nil; _, hash = c.Next() {
+		block, err := GetBlockTx(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if block.IsPruned() {
+			continue
+		}
+
+		err = putFilter(b, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReIndexFilters rebuilds the filters of the main chain.
+func (bc *Blockchain) ReIndexFilters() error {
+	return bc.Db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket([]byte(filterIndexBucket))
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+
+		return buildFilterIndex(tx)
+	})
+}
@@ -0,0 +1,116 @@
+package blockchain_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"bytes"
+	"testing"
+)
+
+func TestFilterIndexByDefault(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+
+	for _, block := range c.Blocks {
+		filter, err := c.BC.GetFilter(block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter == nil {
+			t.Fatalf("no filter for block %x at height %d", block.Hash, block.Height)
+		}
+
+		matched, err := filter.MatchAny(blockchain.FilterKey(block.Hash), [][]byte{block.Transactions[0].Vout[0].ScriptPubKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !matched {
+			t.Fatalf("filter of block %x does not match its coinbase", block.Hash)
+		}
+	}
+}
+
+func TestFilterSpends(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+	prev := c.Blocks[1].Transactions[0]
+	tx := c.Spend(prev, 0)
+	block := c.Mine(c.Tip(), tx)
+
+	filter, err := c.BC.GetFilter(block.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := blockchain.FilterKey(block.Hash)
+	tests := []struct {
+		name string
+		item []byte
+		want bool
+	}{
+		{"output", tx.Vout[0].ScriptPubKey, true},
+		{"spent outpoint", blockchain.FilterOutpoint(prev.ID, 0), true},
+		{"other outpoint", blockchain.FilterOutpoint(prev.ID, 1), false},
+		{"transaction id", tx.ID, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := filter.Match(key, tt.item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != tt.want {
+				t.Fatalf("got %t, want %t", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterKeptOnDisconnect(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+	orphaned := c.Blocks[2]
+
+	fork := c.Mine(c.Blocks[1])
+	tip := c.Mine(fork)
+	if !bytes.Equal(c.Tip().Hash, tip.Hash) {
+		t.Fatal("the chain did not reorganize onto the fork")
+	}
+
+	for _, block := range []*blockchain.Block{orphaned, fork, tip} {
+		filter, err := c.BC.GetFilter(block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter == nil {
+			t.Fatalf("no filter for block %x at height %d", block.Hash, block.Height)
+		}
+	}
+
+	filter, err := c.BC.GetFilter(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter != nil {
+		t.Fatal("unknown block has a filter")
+	}
+}
+
+func TestReIndexFilters(t *testing.T) {
+	c := chaintest.New(t).Extend(3)
+
+	err := c.BC.ReIndexFilters()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, block := range c.Blocks {
+		filter, err := c.BC.GetFilter(block.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := blockchain.BlockFilter(block)
+		if filter == nil || filter.N != want.N || !bytes.Equal(filter.Data, want.Data) {
+			t.Fatalf("filter of block %x at height %d was not rebuilt", block.Hash, block.Height)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+const chainWorkBucket = "chainwork"
+
+// ChainIndex is kept in step with the main chain. Blocks are connected and
+// disconnected inside the same database transaction that moves the tip.
+type ChainIndex interface {
+	ConnectBlock(tx storage.Tx, block *Block) error
+	DisconnectBlock(tx storage.Tx, block *Block) error
+}
+
+func (bc *Blockchain) AddIndex(index ChainIndex) {
+	bc.indexes = append(bc.indexes, index)
+}
+
+func (bc *Blockchain) utxoView() UTXOView {
+	for _, index := range bc.indexes {
+		view, ok := index.(UTXOView)
+		if ok {
+			return view
+		}
+	}
+
+	return nil
+}
+
+// BlockWork is the expected number of hashes to seal a block with bits.
+func BlockWork(bits int) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+	target.Add(target, big.NewInt(1))
+
+	work := big.NewInt(1)
+	work.Lsh(work, 256)
+
+	return work.Div(work, target)
+}
+
+func getChainWork(tx storage.Tx, hash []byte) (*big.Int, error) {
+	wb, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	workData := wb.Get(hash)
+	if workData != nil {
+		return new(big.Int).SetBytes(workData), nil
+	}
+
+	block, err := GetBlockTx(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	work := BlockWork(block.Bits)
+	if len(block.PrevBlockHash) > 0 {
+		parentWork, err := getChainWork(tx, block.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, parentWork)
+	}
+
+	err = wb.Put(hash, work.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return work, nil
+}
+
+func GetBlockTx(tx storage.Tx, hash []byte) (*Block, error) {
+	b := tx.Bucket([]byte(blocksBucket))
+
+	blockData := b.Get(hash)
+	if blockData == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return DeserializeBlock(blockData)
+}
+
+func findFork(tx storage.Tx, oldTip, newTip *Block) ([]*Block, []*Block, error) {
+	var detach []*Block
+	var attach []*Block
+	var err error
+
+	for oldTip.Height > newTip.Height {
+		detach = append(detach, oldTip)
+		oldTip, err = GetBlockTx(tx, oldTip.PrevBlockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for newTip.Height > oldTip.Height {
+		attach = append(attach, newTip)
+		newTip, err = GetBlockTx(tx, newTip.PrevBlockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for !bytes.Equal(oldTip.Hash, newTip.Hash) {
+		detach = append(detach, oldTip)
+		attach = append(attach, newTip)
+
+		oldTip, err = GetBlockTx(tx, oldTip.PrevBlockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		newTip, err = GetBlockTx(tx, newTip.PrevBlockHash)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return detach, attach, nil
+}
+
+// reorganize moves the tip from oldTipHash to newTip and returns the number
+// of blocks disconnected on the way.
+func (bc *Blockchain) reorganize(tx storage.Tx, oldTipHash []byte, newTip *Block) (int, error) {
+	oldTip, err := GetBlockTx(tx, oldTipHash)
+	if err != nil {
+		return 0, err
+	}
+
+	detach, attach, err := findFork(tx, oldTip, newTip)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, block := range detach {
+		if block.IsPruned() {
+			return 0, fmt.Errorf("%w: cannot disconnect block %x", ErrPruned, block.Hash)
+		}
+
+		for _, index := range bc.indexes {
+			err := index.DisconnectBlock(tx, block)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for i := len(attach) - 1; i >= 0; i-- {
+		err := bc.connectBlock(tx, attach[i])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+
+	return len(detach), b.Put([]byte("l"), newTip.Hash)
+}
+
+// connectBlock checks the transactions of block against the outputs its
+// parent leaves unspent, if there is a UTXOView, and connects it to every
+// index.
+func (bc *Blockchain) connectBlock(tx storage.Tx, block *Block) error {
+	view := bc.utxoView()
+	if view != nil {
+		err := bc.ValidateBlockTransactions(block, func(txID []byte) (*transactions.TXOutputs, error) {
+			return view.FetchOutputs(tx, txID)
+		}, bc.assumeValid(tx, block))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, index := range bc.indexes {
+		err := index.ConnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// headerChain is a ChainReader over headers that are not stored yet.
+type headerChain map[string]*Block
+
+func (c headerChain) GetHeader(hash []byte) (*BlockHeader, int, error) {
+	block, ok := c[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, 0, ErrBlockNotFound
+	}
+
+	return &block.BlockHeader, block.Height, nil
+}
+
+func validateHeaders(headers []*Block, p *params.ChainParams) error {
+	engine := consensus.New(p)
+	chain := make(headerChain)
+
+	for i, header := range headers {
+		if !header.IsPruned() {
+			return fmt.Errorf("block %x at height %d is not a header", header.Hash, i)
+		}
+
+		if header.Height != i {
+			return fmt.Errorf("%w: got %d, want %d", ErrBadHeight, header.Height, i)
+		}
+
+		err := CheckHeader(chain, engine, header, p)
+		if err != nil {
+			return err
+		}
+
+		chain[hex.EncodeToString(header.Hash)] = header
+	}
+
+	return nil
+}
+
+// CheckHeader validates what can be checked of a block without its
+// transactions: that it follows its parent in chain, its timestamp, its hash
+// and seal, and any checkpoint at its height.
+func CheckHeader(chain consensus.ChainReader, engine consensus.Engine, header *Block, p *params.ChainParams) error {
+	if header.Height == 0 {
+		if len(header.PrevBlockHash) != 0 {
+			return fmt.Errorf("block %x is not a genesis block", header.Hash)
+		}
+	} else {
+		_, parentHeight, err := chain.GetHeader(header.PrevBlockHash)
+		if errors.Is(err, ErrBlockNotFound) {
+			return fmt.Errorf("%w: %x", ErrUnknownParent, header.PrevBlockHash)
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Height != parentHeight+1 {
+			return fmt.Errorf("%w: got %d, want %d", ErrBadHeight, header.Height, parentHeight+1)
+		}
+	}
+
+	err := checkTimestamp(chain, header, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header.BlockHeader.Hash(), header.Hash) {
+		return fmt.Errorf("%w: hash does not match the header", ErrBadSeal)
+	}
+
+	err = engine.VerifySeal(chain, &header.BlockHeader, header.Height)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSeal, err)
+	}
+
+	checkpoint, ok := p.CheckpointAt(header.Height)
+	if ok && checkpoint.Hash != hex.EncodeToString(header.Hash) {
+		return fmt.Errorf("%w: block %x at height %d, want %s", ErrCheckpointMismatch, header.Hash, header.Height, checkpoint.Hash)
+	}
+
+	return nil
+}
+
+// CreateBlockChainFromHeaders starts a new chain from the headers of every
+// block from genesis to the tip, without their transactions. The chain is
+// treated as pruned up to the tip, so the caller has to provide the UTXO set
+// for it.
+func CreateBlockChainFromHeaders(dataDir string, headers []*Block, p *params.ChainParams) (*Blockchain, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("no headers to start the chain from")
+	}
+
+	err := validateHeaders(headers, p)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := InitDBWithGenesis(dataDir, headers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		for _, header := range headers[1:] {
+			headerData, err := header.Serialize()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(header.Hash, headerData)
+			if err != nil {
+				return err
+			}
+
+			_, err = getChainWork(tx, header.Hash)
+			if err != nil {
+				return err
+			}
+
+			err = heightIndex{}.ConnectBlock(tx, header)
+			if err != nil {
+				return err
+			}
+		}
+
+		tip := headers[len(headers)-1]
+
+		err := b.Put([]byte("l"), tip.Hash)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(pruneHeightKey), heightKey(tip.Height))
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return newBlockchainFromDB(db, p)
+}
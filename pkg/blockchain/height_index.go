@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/utils"
+	"fmt"
+)
+
+const heightsBucket = "heights"
+
+// heightIndex maps main chain heights to block hashes.
+type heightIndex struct{}
+
+func (heightIndex) ConnectBlock(tx storage.Tx, block *Block) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(heightsBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(heightKey(block.Height), block.Hash)
+}
+
+func (heightIndex) DisconnectBlock(tx storage.Tx, block *Block) error {
+	b := tx.Bucket([]byte(heightsBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(heightKey(block.Height))
+}
+
+func heightKey(height int) []byte {
+	return utils.IntToHex(int64(height))
+}
+
+func buildHeightIndex(tx storage.Tx) error {
+	if tx.Bucket([]byte(heightsBucket)) != nil {
+		return nil
+	}
+
+	blocks := tx.Bucket([]byte(blocksBucket))
+	currentHash := blocks.Get([]byte("l"))
+
+	for len(currentHash) > 0 {
+		block, err := GetBlockTx(tx, currentHash)
+		if err != nil {
+			return err
+		}
+
+		err = heightIndex{}.ConnectBlock(tx, block)
+		if err != nil {
+			return err
+		}
+
+		currentHash = block.PrevBlockHash
+	}
+
+	return nil
+}
+
+func (db *DB) GetBlockByHeight(height int) (*Block, error) {
+	var block *Block
+
+	err := db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(heightsBucket))
+		if b == nil {
+			return fmt.Errorf("height index not found")
+		}
+
+		hash := b.Get(heightKey(height))
+		if hash == nil {
+			return ErrBlockNotFound
+		}
+
+		var err error
+		block, err = GetBlockTx(tx, hash)
+
+		return err
+	})
+
+	return block, err
+}
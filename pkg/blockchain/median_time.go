@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+const medianTimeBlocks = 11
+const maxFutureBlockTime = int64(2 * time.Hour / time.Second)
+const maxAllowedOffset = int64(70 * time.Minute / time.Second)
+const maxTimeSamples = 200
+
+type TimeSource interface {
+	AdjustedTime() time.Time
+}
+
+// MedianTimeSource adjusts the local clock by the median offset reported by
+// peers, ignoring the adjustment when peers disagree too much with us.
+// Samples are keyed by the remote host of the connection they came on, not
+// by an address the peer reports, so that one peer holds a single sample.
+type MedianTimeSource struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	// hosts lists the keys of offsets, oldest first.
+	hosts []string
+}
+
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{offsets: make(map[string]int64)}
+}
+
+// AddTimeSample records the time reported by the peer at remote. Only the
+// first sample from a host counts. Once maxTimeSamples hosts are known, the
+// oldest sample makes room for the new one.
+func (m *MedianTimeSource) AddTimeSample(remote net.Addr, peerTime int64) {
+	host := remote.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.offsets[host]; ok {
+		return
+	}
+
+	if len(m.hosts) >= maxTimeSamples {
+		delete(m.offsets, m.hosts[0])
+		m.hosts = m.hosts[1:]
+	}
+
+	m.offsets[host] = peerTime - time.Now().Unix()
+	m.hosts = append(m.hosts, host)
+}
+
+func (m *MedianTimeSource) AdjustedTime() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var offsets []int64
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+
+	now := time.Now()
+	if len(offsets) == 0 {
+		return now
+	}
+
+	offset := median(offsets)
+	if offset > maxAllowedOffset || offset < -maxAllowedOffset {
+		return now
+	}
+
+	return now.Add(time.Duration(offset) * time.Second)
+}
+
+func (bc *Blockchain) adjustedTime() time.Time {
+	if bc.TimeSource == nil {
+		return time.Now()
+	}
+
+	return bc.TimeSource.AdjustedTime()
+}
+
+// CalcPastMedianTime returns the median timestamp of the last
+// medianTimeBlocks blocks ending at hash.
+func (bc *Blockchain) CalcPastMedianTime(hash []byte) (int64, error) {
+	return pastMedianTime(bc, hash)
+}
+
+func pastMedianTime(chain consensus.ChainReader, hash []byte) (int64, error) {
+	var timestamps []int64
+
+	for len(hash) > 0 && len(timestamps) < medianTimeBlocks {
+		header, _, err := chain.GetHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevBlockHash
+	}
+
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+
+	return median(timestamps), nil
+}
+
+func median(values []int64) int64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,55 @@
+package blockchain_test
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"net"
+	"testing"
+	"time"
+)
+
+func peerAddr(host byte, port int) net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, host), Port: port}
+}
+
+// assertOffset checks the adjusted time is about offset away from now.
+func assertOffset(t *testing.T, m *blockchain.MedianTimeSource, offset time.Duration) {
+	t.Helper()
+
+	got := time.Until(m.AdjustedTime())
+	if got < offset-5*time.Second || got > offset+5*time.Second {
+		t.Fatalf("adjusted time is %v from now, want %v", got, offset)
+	}
+}
+
+func TestMedianTimeOnePeer(t *testing.T) {
+	m := blockchain.NewMedianTimeSource()
+	now := time.Now().Unix()
+
+	for i := byte(1); i <= 3; i++ {
+		m.AddTimeSample(peerAddr(i, 6000), now)
+	}
+
+	// One peer reconnecting from new ports keeps a single sample.
+	for port := 40000; port < 41000; port++ {
+		m.AddTimeSample(peerAddr(100, port), now+3600)
+	}
+
+	assertOffset(t, m, 0)
+}
+
+func TestMedianTimeEviction(t *testing.T) {
+	m := blockchain.NewMedianTimeSource()
+	now := time.Now().Unix()
+
+	// The first 200 peers are ahead, the next 101 agree with us and evict the
+	// oldest samples until they are the majority.
+	for i := 0; i < 200; i++ {
+		m.AddTimeSample(&net.TCPAddr{IP: net.IPv4(10, 1, 0, byte(i)), Port: 6000}, now+600)
+	}
+	assertOffset(t, m, 10*time.Minute)
+
+	for i := 0; i < 101; i++ {
+		m.AddTimeSample(&net.TCPAddr{IP: net.IPv4(10, 2, 0, byte(i)), Port: 6000}, now)
+	}
+	assertOffset(t, m, 0)
+}
@@ -0,0 +1,136 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/merkle"
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+var (
+	ErrBadTxOutProof  = errors.New("transaction is not committed to by the block header")
+	ErrNotInMainChain = errors.New("block is not in the main chain")
+)
+
+// TxOutProof shows that a transaction is part of a block to anyone who has
+// the block's header, without the rest of the block.
+type TxOutProof struct {
+	Header      BlockHeader
+	Height      int
+	Transaction *transactions.Transaction
+	Proof       merkle.Proof
+}
+
+func (p *TxOutProof) Serialize() ([]byte, error) {
+	return msgpack.Marshal(p)
+}
+
+func DeserializeTxOutProof(data []byte) (*TxOutProof, error) {
+	var proof TxOutProof
+
+	err := msgpack.Unmarshal(data, &proof)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proof, nil
+}
+
+// Verify checks the proof against its own header only.
+func (p *TxOutProof) Verify() error {
+	if p.Transaction == nil {
+		return ErrBadTxOutProof
+	}
+
+	leaf, err := p.Transaction.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if !merkle.VerifyProof(p.Header.MerkleRoot, leaf, &p.Proof) {
+		return ErrBadTxOutProof
+	}
+
+	return nil
+}
+
+// GetTxOutProof builds the proof that transaction id is in the main chain.
+func (bc *Blockchain) GetTxOutProof(id []byte) (*TxOutProof, error) {
+	_, block, _, err := bc.GetTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, tx := range block.Transactions {
+		if bytes.Equal(tx.ID, id) {
+			return NewTxOutProof(block, i)
+		}
+	}
+
+	return nil, ErrTransactionNotFound
+}
+
+// NewTxOutProof builds the proof that the transaction at index is part of
+// block.
+func NewTxOutProof(block *Block, index int) (*TxOutProof, error) {
+	var leaves [][]byte
+	for _, tx := range block.Transactions {
+		txSerialized, err := tx.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, txSerialized)
+	}
+
+	tree, err := merkle.NewMerkleTree(leaves)
+	if err != nil {
+		return nil, err
+	}
+
+	proof, err := tree.Proof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxOutProof{
+		Header:      block.BlockHeader,
+		Height:      block.Height,
+		Transaction: block.Transactions[index],
+		Proof:       *proof,
+	}, nil
+}
+
+// VerifyTxOutProof checks proof and that its header is in the main chain,
+// and returns the number of confirmations of the transaction. Only headers
+// are needed, so it also works on pruned nodes and on chains loaded from a
+// snapshot.
+func (bc *Blockchain) VerifyTxOutProof(proof *TxOutProof) (int, error) {
+	err := proof.Verify()
+	if err != nil {
+		return 0, err
+	}
+
+	hash := proof.Header.Hash()
+
+	block, err := bc.GetBlockByHeight(proof.Height)
+	if errors.Is(err, ErrBlockNotFound) {
+		return 0, fmt.Errorf("%w: %x", ErrNotInMainChain, hash)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(block.Hash, hash) {
+		return 0, fmt.Errorf("%w: %x", ErrNotInMainChain, hash)
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	return bestHeight - proof.Height + 1, nil
+}
@@ -0,0 +1,138 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MinPruneDepth is the number of recent blocks a pruned node always keeps in
+// full, so that it can still reorganize and serve peers that are close behind.
+const MinPruneDepth = 32
+
+const pruneHeightKey = "p"
+
+var ErrPruned = errors.New("block body has been pruned")
+
+// BlockPruner is implemented by indexes that keep per-block data which is no
+// longer needed once the body of a main chain block has been pruned.
+type BlockPruner interface {
+	PruneBlock(tx storage.Tx, block *Block) error
+}
+
+// OutputIndex is implemented by indexes that can look up the unspent outputs
+// of a transaction. Pruned nodes use it to find transactions whose block
+// bodies are gone.
+type OutputIndex interface {
+	FindOutputs(txID []byte) (*transactions.TXOutputs, error)
+}
+
+// PruneHeight returns the height of the highest pruned block, or zero if
+// nothing has been pruned.
+func (bc *Blockchain) PruneHeight() (int, error) {
+	var height int
+
+	err := bc.Db.View(func(tx storage.Tx) error {
+		height = getPruneHeight(tx.Bucket([]byte(blocksBucket)))
+
+		return nil
+	})
+
+	return height, err
+}
+
+func getPruneHeight(b storage.Bucket) int {
+	data := b.Get([]byte(pruneHeightKey))
+	if data == nil {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// prune drops the transactions of main chain blocks that are more than
+// PruneDepth blocks below tipHeight. The genesis block is always kept.
+func (bc *Blockchain) prune(tx storage.Tx, tipHeight int) error {
+	if bc.PruneDepth == 0 {
+		return nil
+	}
+
+	b := tx.Bucket([]byte(blocksBucket))
+	heights := tx.Bucket([]byte(heightsBucket))
+
+	for height := getPruneHeight(b) + 1; height <= tipHeight-bc.PruneDepth; height++ {
+		hash := heights.Get(heightKey(height))
+
+		block, err := GetBlockTx(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		for _, index := range bc.indexes {
+			pruner, ok := index.(BlockPruner)
+			if !ok {
+				continue
+			}
+
+			err := pruner.PruneBlock(tx, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		block.Transactions = nil
+		blockData, err := block.Serialize()
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(hash, blockData)
+		if err != nil {
+			return err
+		}
+
+		err = b.Put([]byte(pruneHeightKey), heightKey(height))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findPrunedTransaction rebuilds the unspent outputs of a transaction whose
+// block body has been pruned. Only outputs created at or below the prune
+// height are considered, as those are shared by every branch the node can
+// still switch to.
+func (bc *Blockchain) findPrunedTransaction(id []byte) (*transactions.Transaction, int, error) {
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if pruneHeight == 0 {
+		return nil, 0, ErrTransactionNotFound
+	}
+
+	for _, index := range bc.indexes {
+		outputs, ok := index.(OutputIndex)
+		if !ok {
+			continue
+		}
+
+		outs, err := outputs.FindOutputs(id)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if outs == nil || outs.Height > pruneHeight {
+			continue
+		}
+
+		return outs.Transaction(id), outs.Height, nil
+	}
+
+	return nil, 0, fmt.Errorf("%w: %x", ErrTransactionNotFound, id)
+}
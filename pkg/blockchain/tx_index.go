@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const txIndexBucket = "txindex"
+
+var ErrTxIndexCorrupt = errors.New("transaction index is corrupt, run reindextx")
+
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+// txIndex maps transaction ids on the main chain to the block containing
+// them. It is only maintained once the bucket has been built.
+type txIndex struct{}
+
+func (txIndex) ConnectBlock(tx storage.Tx, block *Block) error {
+	b := tx.Bucket([]byte(txIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	return indexBlockTransactions(b, block)
+}
+
+func (idx txIndex) PruneBlock(tx storage.Tx, block *Block) error {
+	return idx.DisconnectBlock(tx, block)
+}
+
+func (txIndex) DisconnectBlock(tx storage.Tx, block *Block) error {
+	b := tx.Bucket([]byte(txIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		err := b.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func indexBlockTransactions(b storage.Bucket, block *Block) error {
+	for i, transaction := range block.Transactions {
+		location, err := msgpack.Marshal(TxLocation{block.Hash, i})
+		if err != nil {
+			return err
+		}
+
+		err = b.Put(transaction.ID, location)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) HasTxIndex() (bool, error) {
+	var enabled bool
+
+	err := bc.Db.View(func(tx storage.Tx) error {
+		enabled = tx.Bucket([]byte(txIndexBucket)) != nil
+
+		return nil
+	})
+
+	return enabled, err
+}
+
+func (bc *Blockchain) ReIndexTransactions() error {
+	return bc.Db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket([]byte(txIndexBucket))
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := tx.CreateBucket([]byte(txIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		c := tx.Bucket([]byte(heightsBucket)).Cursor()
+		for _, hash := c.First(); hash != nil; _, hash = c.Next() {
+			block, err := GetBlockTx(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			err = indexBlockTransactions(b, block)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bc *Blockchain) DropTxIndex() error {
+	return bc.Db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket([]byte(txIndexBucket))
+		if err == storage.ErrBucketNotFound {
+			return nil
+		}
+
+		return err
+	})
+}
+
+func (bc *Blockchain) lookupTransaction(id []byte) (*transactions.Transaction, *Block, bool, error) {
+	var transaction *transactions.Transaction
+	var block *Block
+	var enabled bool
+
+	err := bc.Db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(txIndexBucket))
+		if b == nil {
+			return nil
+		}
+		enabled = true
+
+		locationData := b.Get(id)
+		if locationData == nil {
+			return nil
+		}
+
+		var location TxLocation
+		err := msgpack.Unmarshal(locationData, &location)
+		if err != nil {
+			return err
+		}
+
+		block, err = GetBlockTx(tx, location.BlockHash)
+		if err != nil {
+			return err
+		}
+		if location.Position < 0 || location.Position >= len(block.Transactions) ||
+			!bytes.Equal(block.Transactions[location.Position].ID, id) {
+			return fmt.Errorf("%w: %x is not at position %d of block %x", ErrTxIndexCorrupt, id, location.Position, block.Hash)
+		}
+		transaction = block.Transactions[location.Position]
+
+		return nil
+	})
+
+	return transaction, block, enabled, err
+}
@@ -0,0 +1,350 @@
+package blockchain
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/merkle"
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrBlockNotFound       = errors.New("block not found")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrUnknownParent       = errors.New("block parent is unknown")
+	ErrBadHeight           = errors.New("block height does not follow its parent")
+	ErrTimeTooOld          = errors.New("block timestamp is not after the median time of previous blocks")
+	ErrTimeTooNew          = errors.New("block timestamp is too far in the future")
+	ErrCheckpointMismatch  = errors.New("block does not match a checkpoint")
+	ErrCheckpointConflict  = errors.New("block forks the chain below a checkpoint")
+	ErrBadSeal             = errors.New("block seal is invalid")
+	ErrBadMerkleRoot       = errors.New("block merkle root does not match its transactions")
+	ErrBadCoinbase         = errors.New("block must contain exactly one coinbase transaction")
+	ErrExcessiveSubsidy    = errors.New("coinbase pays more than the block subsidy and fees")
+	ErrDoubleSpend         = errors.New("block spends the same output twice")
+	ErrImmatureSpend       = errors.New("coinbase output spent before maturity")
+	ErrInvalidTransaction  = errors.New("block contains an invalid transaction")
+	ErrBadTxID             = errors.New("transaction id is not the hash of the transaction")
+	ErrDuplicateTx         = errors.New("transaction id already has unspent outputs")
+	ErrMissingOutput       = errors.New("transaction spends an output that does not exist or is already spent")
+)
+
+type InvalidTxError struct {
+	Index int
+	Err   error
+}
+
+func (e *InvalidTxError) Error() string {
+	return fmt.Sprintf("invalid transaction %d: %v", e.Index, e.Err)
+}
+
+func (e *InvalidTxError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidTxError) Is(target error) bool {
+	return target == ErrInvalidTransaction
+}
+
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	parent, err := bc.GetBlock(block.PrevBlockHash)
+	if errors.Is(err, ErrBlockNotFound) {
+		return fmt.Errorf("%w: %x", ErrUnknownParent, block.PrevBlockHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadHeight, block.Height, parent.Height+1)
+	}
+
+	err = bc.checkCheckpoints(block)
+	if err != nil {
+		return err
+	}
+
+	err = bc.validateTimestamp(block)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(block.BlockHeader.Hash(), block.Hash) {
+		return fmt.Errorf("%w: hash does not match the header", ErrBadSeal)
+	}
+
+	err = bc.Engine.VerifySeal(bc, &block.BlockHeader, block.Height)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSeal, err)
+	}
+
+	merkleRoot, err := block.HashTransactions()
+	if errors.Is(err, merkle.ErrNoLeaves) || errors.Is(err, merkle.ErrMutated) {
+		return fmt.Errorf("%w: %w", ErrBadMerkleRoot, err)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(merkleRoot, block.MerkleRoot) {
+		return ErrBadMerkleRoot
+	}
+
+	// With a UTXOView the transactions are validated as the block is
+	// connected, against the outputs its parent leaves unspent.
+	if bc.utxoView() != nil {
+		return nil
+	}
+
+	assumeValid, err := bc.AssumeValid(block)
+	if err != nil {
+		return err
+	}
+
+	return bc.ValidateBlockTransactions(block, bc.ancestorOutputs(block.PrevBlockHash), assumeValid)
+}
+
+// CheckOrphan checks the hash and seal of a block whose parent is unknown,
+// as far as that is possible without the parent.
+func (bc *Blockchain) CheckOrphan(block *Block) error {
+	if !bytes.Equal(block.BlockHeader.Hash(), block.Hash) {
+		return fmt.Errorf("%w: hash does not match the header", ErrBadSeal)
+	}
+
+	err := bc.Engine.CheckSeal(&block.BlockHeader, block.Height)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrBadSeal, err)
+	}
+
+	return nil
+}
+
+// OutputLookup returns the unspent outputs of the transaction txID, or nil
+// if it has none.
+type OutputLookup func(txID []byte) (*transactions.TXOutputs, error)
+
+// UTXOView is implemented by indexes that keep the unspent outputs of the
+// main chain, which blocks are validated against as they are connected.
+type UTXOView interface {
+	FetchOutputs(tx storage.Tx, txID []byte) (*transactions.TXOutputs, error)
+}
+
+// ancestorOutputs finds outputs in the blocks from from back to genesis. It
+// cannot tell whether they have been spent since, so it is only used when
+// there is no UTXOView.
+func (bc *Blockchain) ancestorOutputs(from []byte) OutputLookup {
+	return func(txID []byte) (*transactions.TXOutputs, error) {
+		tx, block, err := bc.findTransaction(from, txID)
+		if errors.Is(err, ErrTransactionNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		outs := &transactions.TXOutputs{
+			Outputs:  make(map[int]transactions.TXOutput),
+			Height:   block.Height,
+			Coinbase: tx.IsCoinbase(),
+		}
+		for idx, out := range tx.Vout {
+			outs.Outputs[idx] = out
+		}
+
+		return outs, nil
+	}
+}
+
+// ValidateBlockTransactions checks the transactions of block against the
+// outputs they spend, as found by lookup. Signatures are not verified if
+// assumeValid is set, see AssumeValid.
+func (bc *Blockchain) ValidateBlockTransactions(block *Block, lookup OutputLookup, assumeValid bool) error {
+	coinbase, err := findCoinbase(block)
+	if err != nil {
+		return err
+	}
+
+	err = validateNoDoubleSpend(block)
+	if err != nil {
+		return err
+	}
+
+	fees := 0
+	for i, tx := range block.Transactions {
+		fee, err := bc.validateTransaction(lookup, block.Height, tx, !assumeValid)
+		if err != nil {
+			return &InvalidTxError{Index: i, Err: err}
+		}
+
+		fees, err = transactions.AddValue(fees, fee, bc.Params.MaxMoney)
+		if err != nil {
+			return fmt.Errorf("block fees: %w", err)
+		}
+	}
+
+	maxValue, err := transactions.AddValue(fees, bc.Params.BlockSubsidy(block.Height), bc.Params.MaxMoney)
+	if err != nil {
+		return fmt.Errorf("block subsidy and fees: %w", err)
+	}
+
+	return validateCoinbaseValue(coinbase, maxValue, bc.Params.MaxMoney)
+}
+
+func (bc *Blockchain) validateTimestamp(block *Block) error {
+	return checkTimestamp(bc, block, bc.adjustedTime())
+}
+
+// checkTimestamp requires block to be later than the median time of the
+// blocks before it and not too far ahead of now.
+func checkTimestamp(chain consensus.ChainReader, block *Block, now time.Time) error {
+	medianTime, err := pastMedianTime(chain, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	if block.Timestamp <= medianTime {
+		return fmt.Errorf("%w: %d <= %d", ErrTimeTooOld, block.Timestamp, medianTime)
+	}
+
+	maxTimestamp := now.Unix() + maxFutureBlockTime
+	if block.Timestamp > maxTimestamp {
+		return fmt.Errorf("%w: %d > %d", ErrTimeTooNew, block.Timestamp, maxTimestamp)
+	}
+
+	return nil
+}
+
+func findCoinbase(block *Block) (*transactions.Transaction, error) {
+	var coinbase *transactions.Transaction
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			if coinbase != nil {
+				return nil, ErrBadCoinbase
+			}
+			coinbase = tx
+		}
+	}
+
+	if coinbase == nil {
+		return nil, ErrBadCoinbase
+	}
+
+	return coinbase, nil
+}
+
+func validateCoinbaseValue(coinbase *transactions.Transaction, maxValue, maxMoney int) error {
+	value := 0
+	for _, out := range coinbase.Vout {
+		var err error
+		value, err = transactions.AddValue(value, out.Value, maxMoney)
+		if err != nil {
+			return fmt.Errorf("coinbase value: %w", err)
+		}
+	}
+
+	if value > maxValue {
+		return fmt.Errorf("%w: %d > %d", ErrExcessiveSubsidy, value, maxValue)
+	}
+
+	return nil
+}
+
+func validateNoDoubleSpend(block *Block) error {
+	spent := make(map[string]bool)
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			outpoint := fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+			if spent[outpoint] {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, outpoint)
+			}
+			spent[outpoint] = true
+		}
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) validateTransaction(lookup OutputLookup, height int, tx *transactions.Transaction, checkSigs bool) (int, error) {
+	hash, err := tx.Hash()
+	if err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(tx.ID, hash) {
+		return 0, fmt.Errorf("%w: %x", ErrBadTxID, tx.ID)
+	}
+
+	existing, err := lookup(tx.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	if existing != nil {
+		return 0, fmt.Errorf("%w: %x", ErrDuplicateTx, tx.ID)
+	}
+
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	prevTXs, prevHeights, err := findPrevOutputs(lookup, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = bc.checkCoinbaseMaturity(tx, prevTXs, prevHeights, height)
+	if err != nil {
+		return 0, err
+	}
+
+	if checkSigs {
+		valid, err := tx.Verify(prevTXs)
+		if err != nil {
+			return 0, err
+		}
+		if !valid {
+			return 0, errors.New("transaction signature verification failed")
+		}
+	}
+
+	return tx.Fee(prevTXs, bc.Params.MaxMoney)
+}
+
+// findPrevOutputs returns the transactions spent by tx, rebuilt from the
+// outputs lookup knows of, and the heights of the blocks that contain them,
+// keyed by hex encoded transaction id.
+func findPrevOutputs(lookup OutputLookup, tx *transactions.Transaction) (map[string]transactions.Transaction, map[string]int, error) {
+	prevTXs := make(map[string]transactions.Transaction)
+	prevHeights := make(map[string]int)
+
+	for _, vin := range tx.Vin {
+		outs, err := lookup(vin.Txid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if outs == nil {
+			return nil, nil, fmt.Errorf("%w: %x:%d", ErrMissingOutput, vin.Txid, vin.Vout)
+		}
+
+		_, ok := outs.Outputs[vin.Vout]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %x:%d", ErrMissingOutput, vin.Txid, vin.Vout)
+		}
+
+		txID := hex.EncodeToString(vin.Txid)
+		prevTXs[txID] = *outs.Transaction(vin.Txid)
+		prevHeights[txID] = outs.Height
+	}
+
+	return prevTXs, prevHeights, nil
+}
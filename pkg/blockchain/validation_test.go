@@ -0,0 +1,96 @@
+package blockchain_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/transactions"
+	"context"
+	"encoding/hex"
+	"errors"
+	"math"
+	"testing"
+)
+
+// spendValues spends the coinbase of block 1, worth the subsidy, to outputs of
+// the given values.
+func spendValues(t *testing.T, c *chaintest.Chain, values ...int) *transactions.Transaction {
+	prev := c.Blocks[1].Transactions[0]
+
+	tx := &transactions.Transaction{Vin: []transactions.TXInput{{Txid: prev.ID, Vout: 0}}}
+	for _, value := range values {
+		tx.Vout = append(tx.Vout, *transactions.NewTXOutput(value, c.Address))
+	}
+
+	err := tx.Sign(c.Wallet.PrivateKey, map[string]transactions.Transaction{hex.EncodeToString(prev.ID): *prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestMoneyRange(t *testing.T) {
+	maxMoney := chaintest.New(t).BC.Params.MaxMoney
+
+	tests := []struct {
+		name   string
+		values []int
+	}{
+		// The outputs wrap around to a negative sum, which looked like a fee.
+		{"overflowing outputs", []int{math.MaxInt, math.MaxInt}},
+		{"output above max money", []int{maxMoney + 1}},
+		{"outputs above max money", []int{maxMoney, 1}},
+		{"negative output", []int{-1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := chaintest.New(t).Extend(3)
+			tx := spendValues(t, c, tt.values...)
+
+			valid, err := c.BC.VerifyTransaction(tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid {
+				t.Fatal("transaction is valid")
+			}
+
+			_, err = c.BC.CalculateFee(tx)
+			if !errors.Is(err, transactions.ErrMoneyRange) {
+				t.Fatalf("fee: got %v, want %v", err, transactions.ErrMoneyRange)
+			}
+
+			err = c.BC.AddBlock(c.Block(c.Tip(), tx))
+			if !errors.Is(err, transactions.ErrMoneyRange) {
+				t.Fatalf("block: got %v, want %v", err, transactions.ErrMoneyRange)
+			}
+		})
+	}
+}
+
+func TestCoinbaseMoneyRange(t *testing.T) {
+	c := chaintest.New(t).Extend(1)
+	tip := c.Tip()
+
+	coinbase, err := transactions.NewCoinbaseTX(c.Address, "", math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbase.Vout = append(coinbase.Vout, coinbase.Vout[0])
+
+	err = coinbase.SetID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := blockchain.NewBlock(context.Background(), c.BC.Engine, c.BC, []*transactions.Transaction{coinbase}, tip.Hash, tip.Height+1, tip.Timestamp+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.BC.AddBlock(block)
+	if !errors.Is(err, transactions.ErrMoneyRange) {
+		t.Fatalf("got %v, want %v", err, transactions.ErrMoneyRange)
+	}
+}
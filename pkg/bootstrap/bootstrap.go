@@ -0,0 +1,172 @@
+// Package bootstrap reads and writes chain bootstrap files: the network
+// magic followed by every main chain block in height order, each prefixed
+// with its length as a big endian uint32.
+package bootstrap
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/params"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxBlockSize bounds the length prefix so a corrupt file cannot make the
+// reader allocate arbitrary amounts of memory.
+const maxBlockSize = 32 << 20
+
+var ErrWrongNetwork = errors.New("bootstrap file belongs to another network")
+
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer, p *params.ChainParams) (*Writer, error) {
+	bw := bufio.NewWriter(w)
+
+	_, err := bw.Write(p.Magic[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{bw}, nil
+}
+
+func (w *Writer) WriteBlock(block *blockchain.Block) error {
+	if block.IsPruned() {
+		return fmt.Errorf("%w: block %x", blockchain.ErrPruned, block.Hash)
+	}
+
+	data, err := block.Serialize()
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w.w, binary.BigEndian, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(data)
+
+	return err
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader, p *params.ChainParams) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	var magic [4]byte
+	_, err := io.ReadFull(br, magic[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap header: %w", err)
+	}
+
+	if !bytes.Equal(magic[:], p.Magic[:]) {
+		return nil, fmt.Errorf("%w: want %s", ErrWrongNetwork, p.Name)
+	}
+
+	return &Reader{br}, nil
+}
+
+// ReadBlock returns the next block, or io.EOF at the end of the file.
+func (r *Reader) ReadBlock() (*blockchain.Block, error) {
+	var length uint32
+
+	err := binary.Read(r.r, binary.BigEndian, &length)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block length: %w", err)
+	}
+
+	if length > maxBlockSize {
+		return nil, fmt.Errorf("block of %d bytes exceeds the maximum of %d", length, maxBlockSize)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r.r, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+
+	// A decoding error may be io.EOF, which must not end the import early.
+	block, err := blockchain.DeserializeBlock(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block: %v", err)
+	}
+
+	return block, nil
+}
+
+// Export writes the main chain from genesis to the tip. progress, if not
+// nil, is called after each block.
+func Export(bc *blockchain.Blockchain, w io.Writer, progress func(height, bestHeight int)) error {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	bw, err := NewWriter(w, bc.Params)
+	if err != nil {
+		return err
+	}
+
+	bci := bc.ForwardIterator(0)
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil || block.Height > bestHeight {
+			break
+		}
+
+		err = bw.WriteBlock(block)
+		if err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(block.Height, bestHeight)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Import connects the remaining blocks of r through the normal validation
+// path. The caller is expected to have read the genesis block already.
+// Blocks the chain already has are skipped. progress, if not nil, is called
+// after each block.
+func Import(bc *blockchain.Blockchain, r *Reader, progress func(height int)) error {
+	for {
+		block, err := r.ReadBlock()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = bc.AddBlock(block)
+		if err != nil {
+			return fmt.Errorf("failed to import block %x at height %d: %w", block.Hash, block.Height, err)
+		}
+
+		if progress != nil {
+			progress(block.Height)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package bootstrap_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/bootstrap"
+	"amdzy/gochain/pkg/params"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func export(t *testing.T, c *chaintest.Chain) []byte {
+	var buf bytes.Buffer
+	err := bootstrap.Export(c.BC, &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// importChain imports data into a new chain and returns it.
+func importChain(t *testing.T, data []byte, p *params.ChainParams) (*blockchain.Blockchain, error) {
+	r, err := bootstrap.NewReader(bytes.NewReader(data), p)
+	if err != nil {
+		return nil, err
+	}
+
+	genesis, err := r.ReadBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	bc, err := blockchain.CreateBlockChainWithGenesis(t.TempDir(), genesis, p)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(bc.CloseDB)
+
+	return bc, bootstrap.Import(bc, r, nil)
+}
+
+func TestExportImport(t *testing.T) {
+	c := chaintest.New(t).Extend(3)
+	c.Mine(c.Tip(), c.Spend(c.Blocks[0].Transactions[0], 1))
+	c.Mine(c.Tip())
+
+	bc, err := importChain(t, export(t, c), c.BC.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, height, err := bc.Db.GetLastHashAndHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tip := c.Tip()
+	if !bytes.Equal(hash, tip.Hash) || height != tip.Height {
+		t.Fatalf("imported tip %x at height %d, want %x at height %d", hash, height, tip.Hash, tip.Height)
+	}
+
+	// Importing again skips the blocks the chain already has.
+	r, err := bootstrap.NewReader(bytes.NewReader(export(t, c)), c.BC.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.ReadBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = bootstrap.Import(bc, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportWrongNetwork(t *testing.T) {
+	c := chaintest.New(t).Extend(1)
+
+	_, err := importChain(t, export(t, c), &params.TestNet)
+	if !errors.Is(err, bootstrap.ErrWrongNetwork) {
+		t.Fatalf("got %v, want %v", err, bootstrap.ErrWrongNetwork)
+	}
+}
+
+func TestImportCorrupt(t *testing.T) {
+	c := chaintest.New(t).Extend(3)
+	data := export(t, c)
+
+	// The last block starts after the magic and the other blocks.
+	last := 4
+	for range c.Blocks[:3] {
+		last += 4 + int(binary.BigEndian.Uint32(data[last:]))
+	}
+
+	tests := []struct {
+		name string
+		data func() []byte
+	}{
+		{"truncated block", func() []byte { return data[:len(data)-1] }},
+		{"truncated length", func() []byte { return data[:last+2] }},
+		{"oversized length", func() []byte {
+			corrupt := bytes.Clone(data)
+			binary.BigEndian.PutUint32(corrupt[last:], 1<<31)
+			return corrupt
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := importChain(t, tt.data(), c.BC.Params)
+			if err == nil {
+				t.Fatal("imported a corrupt bootstrap file")
+			}
+		})
+	}
+
+	// Flipping a byte of the last block must not import a different chain.
+	// Some flips only rename a field the decoder ignores and change nothing.
+	tip := c.Tip()
+	for i := last + 4; i < len(data); i++ {
+		corrupt := bytes.Clone(data)
+		corrupt[i] ^= 0x01
+
+		bc, err := importChain(t, corrupt, c.BC.Params)
+		if err != nil {
+			continue
+		}
+
+		hash, _, err := bc.Db.GetLastHashAndHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hash, tip.Hash) {
+			t.Fatalf("flipping byte %d imported tip %x, want %x", i-last-4, hash, tip.Hash)
+		}
+	}
+}
+
+func TestExportPruned(t *testing.T) {
+	c := chaintest.New(t)
+	c.BC.PruneDepth = 1
+	c.Extend(3)
+
+	err := bootstrap.Export(c.BC, &bytes.Buffer{}, nil)
+	if !errors.Is(err, blockchain.ErrPruned) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrPruned)
+	}
+}
@@ -0,0 +1,61 @@
+package consensus
+
+const maxBitsAdjustment = 2
+
+// CalculateNextBits returns the difficulty for the block following prevHash.
+// Difficulty is recalculated every RetargetInterval blocks so that blocks
+// are produced roughly every TargetBlockTime seconds.
+func (pow *ProofOfWork) CalculateNextBits(chain ChainReader, prevHash []byte) (int, error) {
+	p := pow.params
+
+	if len(prevHash) == 0 {
+		return p.InitialBits, nil
+	}
+
+	prevHeader, prevHeight, err := chain.GetHeader(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	if p.RetargetInterval == 0 || (prevHeight+1)%p.RetargetInterval != 0 {
+		return prevHeader.Bits, nil
+	}
+
+	firstHeader := prevHeader
+	for i := 0; i < p.RetargetInterval-1; i++ {
+		firstHeader, _, err = chain.GetHeader(firstHeader.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	actualTimespan := prevHeader.Timestamp - firstHeader.Timestamp
+	expectedTimespan := int64(p.RetargetInterval-1) * p.TargetBlockTime
+
+	return retarget(prevHeader.Bits, actualTimespan, expectedTimespan, p.MinBits, p.MaxBits), nil
+}
+
+func retarget(bits int, actualTimespan, expectedTimespan int64, minBits, maxBits int) int {
+	if actualTimespan < 1 {
+		actualTimespan = 1
+	}
+	adjustment := 0
+	for actualTimespan*2 <= expectedTimespan && adjustment < maxBitsAdjustment {
+		actualTimespan *= 2
+		adjustment++
+	}
+	for actualTimespan >= expectedTimespan*2 && adjustment > -maxBitsAdjustment {
+		actualTimespan /= 2
+		adjustment--
+	}
+
+	bits += adjustment
+	if bits < minBits {
+		bits = minBits
+	}
+	if bits > maxBits {
+		bits = maxBits
+	}
+
+	return bits
+}
@@ -0,0 +1,124 @@
+package consensus_test
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/params"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// headerChain is a ChainReader over headers that are not stored anywhere.
+type headerChain struct {
+	headers map[string]*consensus.Header
+	heights map[string]int
+}
+
+// newHeaderChain links count headers with the given bits, spacing their
+// timestamps by spacing seconds. It returns the chain and the last hash.
+func newHeaderChain(count, bits int, spacing int64) (*headerChain, []byte) {
+	c := &headerChain{headers: make(map[string]*consensus.Header), heights: make(map[string]int)}
+
+	var prevHash []byte
+	for height := 0; height < count; height++ {
+		header := &consensus.Header{PrevBlockHash: prevHash, Timestamp: int64(height) * spacing, Bits: bits}
+		prevHash = header.Hash()
+
+		c.headers[hex.EncodeToString(prevHash)] = header
+		c.heights[hex.EncodeToString(prevHash)] = height
+	}
+
+	return c, prevHash
+}
+
+func (c *headerChain) GetHeader(hash []byte) (*consensus.Header, int, error) {
+	header, ok := c.headers[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, 0, errors.New("header not found")
+	}
+
+	return header, c.heights[hex.EncodeToString(hash)], nil
+}
+
+func retargetParams() *params.ChainParams {
+	p := params.MainNet
+	p.InitialBits = 10
+	p.MinBits = 8
+	p.MaxBits = 14
+	p.RetargetInterval = 5
+	p.TargetBlockTime = 10
+
+	return &p
+}
+
+func TestRetargetBoundary(t *testing.T) {
+	pow := consensus.NewProofOfWork(retargetParams())
+
+	// Blocks come ten times too fast, so the difficulty rises, but only for
+	// the block at a multiple of RetargetInterval.
+	tests := []struct {
+		count int
+		want  int
+	}{
+		{0, 10},
+		{4, 10},
+		{5, 12},
+		{6, 10},
+		{9, 10},
+		{10, 12},
+	}
+
+	for _, tt := range tests {
+		var bits int
+		var err error
+		if tt.count == 0 {
+			bits, err = pow.CalculateNextBits(nil, nil)
+		} else {
+			chain, tip := newHeaderChain(tt.count, 10, 1)
+			bits, err = pow.CalculateNextBits(chain, tip)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bits != tt.want {
+			t.Errorf("next bits after %d blocks = %d, want %d", tt.count, bits, tt.want)
+		}
+	}
+}
+
+func TestRetargetLimits(t *testing.T) {
+	pow := consensus.NewProofOfWork(retargetParams())
+
+	tests := []struct {
+		name    string
+		bits    int
+		spacing int64
+		want    int
+	}{
+		{"on target", 10, 10, 10},
+		{"slightly fast", 10, 6, 10},
+		{"twice as fast", 10, 5, 11},
+		{"adjustment is limited", 10, 1, 12},
+		{"same timestamps", 10, 0, 12},
+		{"twice as slow", 10, 20, 9},
+		{"slow adjustment is limited", 10, 1000, 8},
+		{"max bits", 13, 1, 14},
+		{"min bits", 9, 1000, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, tip := newHeaderChain(5, tt.bits, tt.spacing)
+
+			bits, err := pow.CalculateNextBits(chain, tip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if bits != tt.want {
+				t.Fatalf("got %d, want %d", bits, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package consensus
+
+import (
+	"amdzy/gochain/pkg/params"
+	"context"
+	"errors"
+)
+
+var (
+	ErrBadBits            = errors.New("header bits do not match the expected difficulty")
+	ErrBadProofOfWork     = errors.New("header hash does not meet the target")
+	ErrMissingSignature   = errors.New("header is not signed")
+	ErrBadSignature       = errors.New("header signature is invalid")
+	ErrNotInTurn          = errors.New("signer is not in turn to seal this block")
+	ErrUnauthorizedSigner = errors.New("signer is not an authority")
+	ErrNoAuthorities      = errors.New("no authorities are configured")
+)
+
+// ChainReader gives engines access to the headers they build on.
+type ChainReader interface {
+	GetHeader(hash []byte) (*Header, int, error)
+}
+
+// Engine decides who may produce a block and how the result is sealed.
+type Engine interface {
+	// Prepare sets the consensus fields of a header that will sit at height.
+	Prepare(chain ChainReader, header *Header, height int) error
+	// Seal completes a prepared header and returns its hash.
+	Seal(ctx context.Context, chain ChainReader, header *Header, height int) ([]byte, error)
+	// VerifySeal checks the consensus fields of a header received for height.
+	VerifySeal(chain ChainReader, header *Header, height int) error
+	// CheckSeal checks what VerifySeal can without the chain header builds
+	// on, to screen blocks whose parent is not known yet.
+	CheckSeal(header *Header, height int) error
+}
+
+// New returns the engine configured for the network. Proof of authority
+// engines returned here can verify blocks but have no key to seal them with.
+func New(p *params.ChainParams) Engine {
+	if p.ProofOfAuthority {
+		return NewProofOfAuthority(p, nil)
+	}
+
+	return NewProofOfWork(p)
+}
@@ -0,0 +1,56 @@
+package consensus
+
+import (
+	"amdzy/gochain/utils"
+	"bytes"
+	"crypto/sha256"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type Header struct {
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          int
+	Nonce         uint32
+	// Signature is set by engines that seal blocks with a key. It is not
+	// part of the data it signs.
+	Signature []byte
+}
+
+func (h *Header) hashData() []byte {
+	return bytes.Join([][]byte{
+		utils.IntToHex(int64(h.Version)),
+		h.PrevBlockHash,
+		h.MerkleRoot,
+		utils.IntToHex(h.Timestamp),
+		utils.IntToHex(int64(h.Bits)),
+		utils.IntToHex(int64(h.Nonce)),
+	}, []byte{})
+}
+
+// SealHash is the hash of the header without its signature.
+func (h *Header) SealHash() []byte {
+	hash := sha256.Sum256(h.hashData())
+
+	return hash[:]
+}
+
+func (h *Header) Hash() []byte {
+	hash := sha256.Sum256(append(h.hashData(), h.Signature...))
+
+	return hash[:]
+}
+
+func (h *Header) Serialize() ([]byte, error) {
+	return msgpack.Marshal(h)
+}
+
+func DeserializeHeader(b []byte) (*Header, error) {
+	var header Header
+	err := msgpack.Unmarshal(b, &header)
+
+	return &header, err
+}
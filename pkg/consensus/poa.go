@@ -0,0 +1,138 @@
+package consensus
+
+import (
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/wallet"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Every proof of authority block carries the same bits, so each one adds the
+// same amount of work and the longest chain wins.
+const authorityBits = 1
+
+const signatureLen = 64
+
+// ProofOfAuthority lets the configured authorities take turns producing
+// blocks: the block at height h must be signed by Authorities[h % n]. The
+// genesis block is not signed.
+type ProofOfAuthority struct {
+	params *params.ChainParams
+	signer *ecdsa.PrivateKey
+}
+
+func (poa *ProofOfAuthority) Prepare(chain ChainReader, header *Header, height int) error {
+	header.Bits = authorityBits
+	header.Nonce = 0
+
+	if height == 0 {
+		return nil
+	}
+
+	if len(poa.params.Authorities) == 0 {
+		return ErrNoAuthorities
+	}
+
+	if poa.signer == nil {
+		return errors.New("proof of authority requires a signing key")
+	}
+
+	pubKey := wallet.EncodePublicKey(&poa.signer.PublicKey)
+	if !poa.isAuthority(pubKey) {
+		return ErrUnauthorizedSigner
+	}
+
+	if !bytes.Equal(pubKey, poa.inTurn(height)) {
+		return fmt.Errorf("%w at height %d", ErrNotInTurn, height)
+	}
+
+	return nil
+}
+
+func (poa *ProofOfAuthority) Seal(ctx context.Context, chain ChainReader, header *Header, height int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	header.Signature = nil
+
+	if height > 0 {
+		r, s, err := ecdsa.Sign(rand.Reader, poa.signer, header.SealHash())
+		if err != nil {
+			return nil, err
+		}
+
+		signature := make([]byte, signatureLen)
+		r.FillBytes(signature[:signatureLen/2])
+		s.FillBytes(signature[signatureLen/2:])
+		header.Signature = signature
+	}
+
+	return header.Hash(), nil
+}
+
+func (poa *ProofOfAuthority) VerifySeal(chain ChainReader, header *Header, height int) error {
+	if header.Bits != authorityBits {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadBits, header.Bits, authorityBits)
+	}
+
+	if height == 0 {
+		return nil
+	}
+
+	if len(poa.params.Authorities) == 0 {
+		return ErrNoAuthorities
+	}
+
+	if len(header.Signature) != signatureLen {
+		return ErrMissingSignature
+	}
+
+	authority := poa.inTurn(height)
+
+	pubKey, err := wallet.DecodePublicKey(authority)
+	if err != nil {
+		return fmt.Errorf("authority %x: %w", authority, err)
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	r.SetBytes(header.Signature[:signatureLen/2])
+	s.SetBytes(header.Signature[signatureLen/2:])
+
+	if !ecdsa.Verify(pubKey, header.SealHash(), &r, &s) {
+		return fmt.Errorf("%w: expected a signature from %x", ErrBadSignature, authority)
+	}
+
+	return nil
+}
+
+// CheckSeal is VerifySeal, the signer in turn only depends on height.
+func (poa *ProofOfAuthority) CheckSeal(header *Header, height int) error {
+	return poa.VerifySeal(nil, header, height)
+}
+
+func (poa *ProofOfAuthority) inTurn(height int) []byte {
+	return poa.params.Authorities[height%len(poa.params.Authorities)]
+}
+
+func (poa *ProofOfAuthority) isAuthority(pubKey []byte) bool {
+	for _, authority := range poa.params.Authorities {
+		if bytes.Equal(authority, pubKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewProofOfAuthority returns an engine for p.Authorities. signer may be nil
+// for nodes that only verify blocks.
+func NewProofOfAuthority(p *params.ChainParams, signer *ecdsa.PrivateKey) *ProofOfAuthority {
+	return &ProofOfAuthority{params: p, signer: signer}
+}
@@ -0,0 +1,50 @@
+package consensus_test
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/wallet"
+	"context"
+	"crypto/ecdsa"
+	"testing"
+)
+
+// shortKey returns a key whose X coordinate has a leading zero byte, so that
+// its unpadded encoding is shorter than 64 bytes.
+func shortKey(t *testing.T) *ecdsa.PrivateKey {
+	for {
+		private, _, err := wallet.NewKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(private.PublicKey.X.Bytes()) < 32 {
+			return &private
+		}
+	}
+}
+
+func TestProofOfAuthorityShortKey(t *testing.T) {
+	signer := shortKey(t)
+
+	p := params.PoATest
+	p.Authorities = [][]byte{wallet.EncodePublicKey(&signer.PublicKey)}
+
+	engine := consensus.NewProofOfAuthority(&p, signer)
+	header := &consensus.Header{Timestamp: 1}
+
+	err := engine.Prepare(nil, header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = engine.Seal(context.Background(), nil, header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consensus.NewProofOfAuthority(&p, nil).VerifySeal(nil, header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
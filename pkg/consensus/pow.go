@@ -0,0 +1,193 @@
+package consensus
+
+import (
+	"amdzy/gochain/pkg/params"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxNonce         = math.MaxUint32
+	hashRateInterval = 5 * time.Second
+	// workers poll for cancellation and flush their hash count this often
+	checkInterval = 1 << 12
+)
+
+type ProofOfWork struct {
+	params *params.ChainParams
+
+	// Workers is the number of goroutines sharing the nonce space. Zero or
+	// less means one per CPU.
+	Workers int
+	// OnHashRate, if set, is called periodically with the hashes per second.
+	OnHashRate func(hashRate float64)
+}
+
+type powSolution struct {
+	nonce uint32
+	hash  []byte
+}
+
+func (pow *ProofOfWork) Prepare(chain ChainReader, header *Header, height int) error {
+	bits, err := pow.CalculateNextBits(chain, header.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	header.Bits = bits
+
+	return nil
+}
+
+// Seal searches for a nonce that satisfies the target and stores it in the
+// header. When the whole nonce space is exhausted the timestamp is bumped and
+// the search starts over. It returns ctx.Err() once ctx is done.
+func (pow *ProofOfWork) Seal(ctx context.Context, chain ChainReader, header *Header, height int) ([]byte, error) {
+	workers := pow.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var hashes atomic.Uint64
+	stop := pow.reportHashRate(&hashes)
+	defer stop()
+
+	target := Target(header.Bits)
+
+	for {
+		solution, err := pow.search(ctx, *header, target, workers, &hashes)
+		if err != nil {
+			return nil, err
+		}
+
+		if solution != nil {
+			header.Nonce = solution.nonce
+
+			return solution.hash, nil
+		}
+
+		header.Timestamp++
+	}
+}
+
+func (pow *ProofOfWork) VerifySeal(chain ChainReader, header *Header, height int) error {
+	expectedBits, err := pow.CalculateNextBits(chain, header.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	if header.Bits != expectedBits {
+		return fmt.Errorf("%w: got %d, want %d", ErrBadBits, header.Bits, expectedBits)
+	}
+
+	return pow.CheckSeal(header, height)
+}
+
+// CheckSeal checks that the bits of header are within the limits of the
+// network and that its hash meets them.
+func (pow *ProofOfWork) CheckSeal(header *Header, height int) error {
+	if header.Bits < pow.params.MinBits || header.Bits > pow.params.MaxBits {
+		return fmt.Errorf("%w: %d is outside %d-%d", ErrBadBits, header.Bits, pow.params.MinBits, pow.params.MaxBits)
+	}
+
+	var hashInt big.Int
+	hashInt.SetBytes(header.Hash())
+	if hashInt.Cmp(Target(header.Bits)) != -1 {
+		return ErrBadProofOfWork
+	}
+
+	return nil
+}
+
+func (pow *ProofOfWork) search(ctx context.Context, header Header, target *big.Int, workers int, hashes *atomic.Uint64) (*powSolution, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	solutions := make(chan powSolution, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(start uint64) {
+			defer wg.Done()
+
+			h := header
+			var hashInt big.Int
+			var count uint64
+
+			for nonce := start; nonce <= maxNonce; nonce += uint64(workers) {
+				count++
+				if count%checkInterval == 0 {
+					hashes.Add(checkInterval)
+					if ctx.Err() != nil {
+						return
+					}
+				}
+
+				h.Nonce = uint32(nonce)
+				hash := sha256.Sum256(h.hashData())
+				hashInt.SetBytes(hash[:])
+
+				if hashInt.Cmp(target) == -1 {
+					solutions <- powSolution{uint32(nonce), hash[:]}
+					cancel()
+
+					return
+				}
+			}
+		}(uint64(w))
+	}
+
+	wg.Wait()
+	close(solutions)
+
+	if solution, ok := <-solutions; ok {
+		return &solution, nil
+	}
+
+	// the parent context is done, or the nonce space ran out
+	return nil, context.Cause(ctx)
+}
+
+func (pow *ProofOfWork) reportHashRate(hashes *atomic.Uint64) func() {
+	if pow.OnHashRate == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	start := time.Now()
+
+	go func() {
+		ticker := time.NewTicker(hashRateInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				pow.OnHashRate(float64(hashes.Load()) / time.Since(start).Seconds())
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Target returns the value a block hash must be below for the given bits.
+func Target(bits int) *big.Int {
+	target := big.NewInt(1)
+
+	return target.Lsh(target, uint(256-bits))
+}
+
+func NewProofOfWork(p *params.ChainParams) *ProofOfWork {
+	return &ProofOfWork{params: p}
+}
@@ -0,0 +1,94 @@
+package consensus_test
+
+import (
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/params"
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func powParams(bits int) *params.ChainParams {
+	p := params.RegTest
+	p.InitialBits = bits
+	p.MinBits = bits
+	p.MaxBits = bits
+
+	return &p
+}
+
+func TestSealWorkers(t *testing.T) {
+	pow := consensus.NewProofOfWork(powParams(12))
+	pow.Workers = 4
+
+	header := &consensus.Header{Timestamp: 1, Bits: 12}
+
+	hash, err := pow.Seal(context.Background(), nil, header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pow.CheckSeal(header, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, header.Hash()) {
+		t.Fatalf("Seal returned %x, the header hashes to %x", hash, header.Hash())
+	}
+}
+
+func TestSealCancel(t *testing.T) {
+	// No hash can meet this target, so the workers only stop when the
+	// context is cancelled.
+	pow := consensus.NewProofOfWork(powParams(255))
+	pow.Workers = 4
+
+	goroutines := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := pow.Seal(ctx, nil, &consensus.Header{Bits: 255}, 1)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n < goroutines+pow.Workers {
+		t.Fatalf("%d goroutines while sealing, want at least %d", n, goroutines+pow.Workers)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Seal did not return after the context was cancelled")
+	}
+
+	// The goroutine calling Seal may take a moment to exit after sending
+	// its result, but the workers have to be gone.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("%d goroutines left running, want %d", n, goroutines)
+	}
+}
+
+func TestSealCancelledContext(t *testing.T) {
+	pow := consensus.NewProofOfWork(powParams(255))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := pow.Seal(ctx, nil, &consensus.Header{Bits: 255}, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,61 @@
+package datadir
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const lockFile = "LOCK"
+
+var ErrLocked = errors.New("data directory is in use by another process")
+
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".gochain"
+	}
+
+	return filepath.Join(home, ".gochain")
+}
+
+func NetworkDir(dataDir, network string) (string, error) {
+	dir := filepath.Join(dataDir, network)
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+type Lock struct {
+	file *os.File
+}
+
+func AcquireLock(dir string) (*Lock, error) {
+	file, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFileExclusive(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s", ErrLocked, dir)
+	}
+
+	return &Lock{file}, nil
+}
+
+func (l *Lock) Release() error {
+	err := unlockFile(l.file)
+	if err != nil {
+		l.file.Close()
+		return err
+	}
+
+	return l.file.Close()
+}
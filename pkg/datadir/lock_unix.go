@@ -0,0 +1,16 @@
+//go:build !windows
+
+package datadir
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFileExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
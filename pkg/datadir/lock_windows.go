@@ -0,0 +1,15 @@
+//go:build windows
+
+package datadir
+
+import "os"
+
+// bbolt already holds an exclusive lock on the database file on Windows,
+// so the lock file only marks the directory as in use.
+func lockFileExclusive(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package gcs implements Golomb-coded sets: compact probabilistic filters
+// that answer whether an item may be in a set, with a false positive rate of
+// about 1/M and no false negatives.
+//
+// Items are hashed under a key to integers below N*M, sorted, and the
+// differences between consecutive values are Golomb-Rice coded with
+// parameter P.
+package gcs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"slices"
+)
+
+const (
+	P = 19
+	M = 784931
+)
+
+var ErrCorruptFilter = errors.New("filter data is corrupt")
+
+type Filter struct {
+	N    int
+	Data []byte
+}
+
+// Build returns the filter of the distinct items under key.
+func Build(key []byte, items [][]byte) *Filter {
+	seen := make(map[string]bool)
+	var distinct [][]byte
+	for _, item := range items {
+		if !seen[string(item)] {
+			seen[string(item)] = true
+			distinct = append(distinct, item)
+		}
+	}
+
+	values := hashItems(key, distinct, uint64(len(distinct))*M)
+
+	var w bitWriter
+	last := uint64(0)
+	for _, value := range values {
+		delta := value - last
+		last = value
+
+		for q := delta >> P; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, P)
+	}
+
+	return &Filter{N: len(distinct), Data: w.bytes}
+}
+
+// Match reports whether item may be in the filter built under key.
+func (f *Filter) Match(key, item []byte) (bool, error) {
+	return f.MatchAny(key, [][]byte{item})
+}
+
+// MatchAny reports whether any of items may be in the filter built under
+// key.
+func (f *Filter) MatchAny(key []byte, items [][]byte) (bool, error) {
+	if f.N == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	// Each value takes at least P+1 bits.
+	if f.N < 0 || f.N > len(f.Data)*8/(P+1) {
+		return false, ErrCorruptFilter
+	}
+
+	targets := hashItems(key, items, uint64(f.N)*M)
+
+	r := bitReader{data: f.Data}
+	value := uint64(0)
+	for i := 0; i < f.N; i++ {
+		delta, err := r.readDelta()
+		if err != nil {
+			return false, err
+		}
+		value += delta
+
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false, nil
+		}
+		if targets[0] == value {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hashItems maps each item uniformly onto [0, limit) and sorts the results.
+func hashItems(key []byte, items [][]byte, limit uint64) []uint64 {
+	values := make([]uint64, 0, len(items))
+	for _, item := range items {
+		hash := sha256.Sum256(append(key[:len(key):len(key)], item...))
+		value, _ := bits.Mul64(binary.BigEndian.Uint64(hash[:8]), limit)
+		values = append(values, value)
+	}
+	slices.Sort(values)
+
+	return values
+}
+
+type bitWriter struct {
+	bytes []byte
+	used  uint
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.used%8 == 0 {
+		w.bytes = append(w.bytes, 0)
+	}
+
+	if bit != 0 {
+		w.bytes[len(w.bytes)-1] |= 0x80 >> (w.used % 8)
+	}
+	w.used++
+}
+
+func (w *bitWriter) writeBits(value uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(value >> (i - 1) & 1)
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos/8 >= uint(len(r.data)) {
+		return 0, ErrCorruptFilter
+	}
+
+	bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+	r.pos++
+
+	return uint64(bit), nil
+}
+
+func (r *bitReader) readDelta() (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		quotient++
+	}
+
+	remainder := uint64(0)
+	for i := 0; i < P; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		remainder = remainder<<1 | bit
+	}
+
+	return quotient<<P | remainder, nil
+}
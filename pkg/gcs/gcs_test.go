@@ -0,0 +1,166 @@
+package gcs_test
+
+import (
+	"amdzy/gochain/pkg/gcs"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+var key = []byte("block hash")
+
+func randomItems(t *testing.T, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = make([]byte, 20)
+		_, err := rand.Read(items[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return items
+}
+
+func TestMatch(t *testing.T) {
+	for _, n := range []int{1, 2, 10, 1000} {
+		items := randomItems(t, n)
+		filter := gcs.Build(key, append(items, items[0]))
+
+		if filter.N != n {
+			t.Fatalf("filter of %d distinct items has N = %d", n, filter.N)
+		}
+
+		for i, item := range items {
+			match, err := filter.Match(key, item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !match {
+				t.Fatalf("item %d of %d does not match", i, n)
+			}
+		}
+
+		match, err := filter.MatchAny(key, append(randomItems(t, 10), items[n-1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !match {
+			t.Fatalf("items with the last of %d do not match", n)
+		}
+	}
+}
+
+// counters returns n items made of prefix and a counter, which are as good
+// as random once hashed under the key, but keep the test deterministic.
+func counters(prefix string, from, n int) [][]byte {
+	items := make([][]byte, n)
+	for i := range items {
+		items[i] = binary.BigEndian.AppendUint64([]byte(prefix), uint64(from+i))
+	}
+
+	return items
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("matches millions of items")
+	}
+
+	filter := gcs.Build(key, counters("member", 0, 100))
+
+	// MatchAny stops at the first match, so positive batches are matched
+	// again item by item.
+	const batches, batchSize = 400, 10000
+	positives := 0
+	for i := 0; i < batches; i++ {
+		batch := counters("non-member", i*batchSize, batchSize)
+
+		match, err := filter.MatchAny(key, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !match {
+			continue
+		}
+
+		for _, item := range batch {
+			match, err := filter.Match(key, item)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match {
+				positives++
+			}
+		}
+	}
+
+	want := float64(batches*batchSize) / gcs.M
+	if float64(positives) < want/4 || float64(positives) > want*4 {
+		t.Fatalf("%d false positives in %d items, want about %.1f", positives, batches*batchSize, want)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	filter := gcs.Build(key, nil)
+	if filter.N != 0 || len(filter.Data) != 0 {
+		t.Fatalf("empty filter has N = %d and %d bytes", filter.N, len(filter.Data))
+	}
+
+	match, err := filter.MatchAny(key, randomItems(t, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Fatal("empty filter matches")
+	}
+
+	match, err = gcs.Build(key, randomItems(t, 10)).MatchAny(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Fatal("no items match")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	items := randomItems(t, 50)
+	filter := gcs.Build(key, items)
+	last := items[len(items)-1]
+
+	tests := []struct {
+		name   string
+		filter gcs.Filter
+	}{
+		{"truncated", gcs.Filter{N: filter.N, Data: filter.Data[:len(filter.Data)/2]}},
+		{"no data", gcs.Filter{N: filter.N}},
+		{"N too large", gcs.Filter{N: filter.N * 100, Data: filter.Data}},
+		{"N overflows", gcs.Filter{N: int(^uint(0) >> 1), Data: filter.Data}},
+		{"negative N", gcs.Filter{N: -1, Data: filter.Data}},
+		// Only 1 bits never end a quotient.
+		{"all ones", gcs.Filter{N: 2, Data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every item is matched so the whole filter is decoded.
+			_, err := tt.filter.MatchAny(key, append(items, last))
+			if !errors.Is(err, gcs.ErrCorruptFilter) {
+				t.Fatalf("got %v, want %v", err, gcs.ErrCorruptFilter)
+			}
+		})
+	}
+
+	// Flipped bits decode to other values, but never panic.
+	for i := range filter.Data {
+		corrupt := gcs.Filter{N: filter.N, Data: append([]byte{}, filter.Data...)}
+		corrupt.Data[i] ^= 0x5a
+
+		_, err := corrupt.MatchAny(key, items)
+		if err != nil && !errors.Is(err, gcs.ErrCorruptFilter) {
+			t.Fatalf("byte %d: got %v, want %v", i, err, gcs.ErrCorruptFilter)
+		}
+	}
+}
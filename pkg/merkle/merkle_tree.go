@@ -1,9 +1,24 @@
 package merkle
 
-import "crypto/sha256"
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrNoLeaves = errors.New("merkle tree has no leaves")
+	ErrMutated  = errors.New("merkle tree has duplicate subtrees")
+)
 
 type MerkleTree struct {
 	RootNode *MerkleNode
+	// Mutated is set when two sibling nodes are equal. Since every level
+	// with an odd number of nodes is padded by repeating its last node, a
+	// list of leaves ending in a repeated run has the same root as the list
+	// without the repetition, and such trees must not be trusted.
+	Mutated bool
+	leaves  int
 }
 
 type MerkleNode struct {
@@ -19,7 +34,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		hash := sha256.Sum256(data)
 		mNode.Data = hash[:]
 	} else {
-		prevHashes := append(left.Data, right.Data...)
+		prevHashes := append(left.Data[:len(left.Data):len(left.Data)], right.Data...)
 		hash := sha256.Sum256(prevHashes)
 		mNode.Data = hash[:]
 	}
@@ -30,30 +45,40 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 	return &mNode
 }
 
-func NewMerkleTree(data [][]byte) *MerkleTree {
-	var nodes []MerkleNode
-
-	if len(data)%2 != 0 {
-		data = append(data, data[len(data)-1])
+// NewMerkleTree builds the tree over data. Each level with an odd number of
+// nodes, including a leaf level of a single node, is padded by repeating
+// its last node before being paired up into the level above.
+func NewMerkleTree(data [][]byte) (*MerkleTree, error) {
+	if len(data) == 0 {
+		return nil, ErrNoLeaves
 	}
 
+	var nodes []*MerkleNode
 	for _, datum := range data {
-		node := NewMerkleNode(nil, nil, datum)
-		nodes = append(nodes, *node)
+		nodes = append(nodes, NewMerkleNode(nil, nil, datum))
 	}
 
-	for i := 0; i < len(data)/2; i++ {
-		var newLevel []MerkleNode
+	mutated := false
+	for {
+		size := len(nodes)
+		if size%2 != 0 {
+			nodes = append(nodes, nodes[size-1])
+		}
 
+		var newLevel []*MerkleNode
 		for j := 0; j < len(nodes); j += 2 {
-			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
-			newLevel = append(newLevel, *node)
+			if j+1 < size && bytes.Equal(nodes[j].Data, nodes[j+1].Data) {
+				mutated = true
+			}
+
+			newLevel = append(newLevel, NewMerkleNode(nodes[j], nodes[j+1], nil))
 		}
 
 		nodes = newLevel
+		if len(nodes) == 1 {
+			break
+		}
 	}
 
-	mTree := MerkleTree{RootNode: &nodes[0]}
-
-	return &mTree
+	return &MerkleTree{RootNode: nodes[0], Mutated: mutated, leaves: len(data)}, nil
 }
@@ -0,0 +1,90 @@
+package merkle_test
+
+import (
+	"amdzy/gochain/pkg/merkle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func leaves(names ...string) [][]byte {
+	data := make([][]byte, len(names))
+	for i, name := range names {
+		data[i] = []byte(name)
+	}
+
+	return data
+}
+
+// txLeaves returns the leaves "tx0" to "tx<n-1>".
+func txLeaves(n int) [][]byte {
+	data := make([][]byte, n)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("tx%d", i))
+	}
+
+	return data
+}
+
+// The roots were computed by a separate implementation of the same scheme:
+// leaves are hashed with sha256, parents are the sha256 of their children's
+// concatenated hashes and odd levels repeat their last node.
+func TestMerkleRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    [][]byte
+		root    string
+		mutated bool
+	}{
+		{"1 leaf", txLeaves(1), "20eec00fe64e75aae422c6bafa69fac09381cf41ff1d14fdb9a34764fbf99149", false},
+		{"2 leaves", txLeaves(2), "9db4d4c69f3d7236f4de569987d746845d8d85250703351226c5a3cdaf1f66ea", false},
+		{"3 leaves", txLeaves(3), "726da7d399987671da491c4886e07dacd532fb6e7e48862732701d2443e3b532", false},
+		{"5 leaves", txLeaves(5), "16eef23ee6e2c2a9a42a944da0f25b543af1d834b60e594d60c417ed4e38cb1a", false},
+		{"7 leaves", txLeaves(7), "d2c110e12bfb6fc669169ebd66d11e3ae64a6ddc24c5c18df03d96caba3f4e6c", false},
+
+		// Each mutated tree has the same root as the tree without its
+		// duplicated subtree.
+		{"a a", leaves("a", "a"), "251a262291b87cb3c93a6ed71865da1f2c090c3d0196661a8f4a705b65836f71", true},
+		{"a", leaves("a"), "251a262291b87cb3c93a6ed71865da1f2c090c3d0196661a8f4a705b65836f71", false},
+		{"a b c c", leaves("a", "b", "c", "c"), "d31a37ef6ac14a2db1470c4316beb5592e6afd4465022339adafda76a18ffabe", true},
+		{"a b c", leaves("a", "b", "c"), "d31a37ef6ac14a2db1470c4316beb5592e6afd4465022339adafda76a18ffabe", false},
+		{"a b c d e f e f", leaves("a", "b", "c", "d", "e", "f", "e", "f"), "44205acec5156114821f1f71d87c72e0de395633cd1589def6d4444cc79f8103", true},
+		{"a b c d e f", leaves("a", "b", "c", "d", "e", "f"), "44205acec5156114821f1f71d87c72e0de395633cd1589def6d4444cc79f8103", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := merkle.NewMerkleTree(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if root := hex.EncodeToString(tree.RootNode.Data); root != tt.root {
+				t.Errorf("root = %s, want %s", root, tt.root)
+			}
+			if tree.Mutated != tt.mutated {
+				t.Errorf("mutated = %t, want %t", tree.Mutated, tt.mutated)
+			}
+
+			// Leaves in a duplicated subtree cannot be told from padding.
+			for i, leaf := range tt.data {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !tt.mutated && !merkle.VerifyProof(tree.RootNode.Data, leaf, proof) {
+					t.Errorf("proof of leaf %d does not verify", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleNoLeaves(t *testing.T) {
+	_, err := merkle.NewMerkleTree(nil)
+	if !errors.Is(err, merkle.ErrNoLeaves) {
+		t.Fatalf("got %v, want %v", err, merkle.ErrNoLeaves)
+	}
+}
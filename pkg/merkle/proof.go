@@ -0,0 +1,81 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+var ErrIndexOutOfRange = errors.New("leaf index out of range")
+
+// Proof is the path from a leaf to the root of a tree: the position of the
+// leaf among the Leaves leaves of the tree and the hashes of the siblings of
+// every node on the path, bottom up. Bit i of Index tells whether the node
+// at height i is a right child.
+type Proof struct {
+	Index  int
+	Leaves int
+	Hashes [][]byte
+}
+
+// Proof returns the inclusion proof of the leaf at index.
+func (t *MerkleTree) Proof(index int) (*Proof, error) {
+	if index < 0 || index >= t.leaves {
+		return nil, fmt.Errorf("%w: %d of %d", ErrIndexOutOfRange, index, t.leaves)
+	}
+
+	depth := 0
+	for node := t.RootNode; node.Left != nil; node = node.Left {
+		depth++
+	}
+
+	hashes := make([][]byte, depth)
+	node := t.RootNode
+	for level := depth - 1; level >= 0; level-- {
+		if index>>level&1 == 0 {
+			hashes[level] = node.Right.Data
+			node = node.Left
+		} else {
+			hashes[level] = node.Left.Data
+			node = node.Right
+		}
+	}
+
+	return &Proof{Index: index, Leaves: t.leaves, Hashes: hashes}, nil
+}
+
+// VerifyProof reports whether proof shows that leaf, the unhashed data of a
+// leaf, is part of the tree with the given root. The path has to fit a tree
+// of proof.Leaves leaves: a node is paired with a copy of itself exactly
+// when it is the last of a level with an odd number of nodes, so the padding
+// added to such a level cannot be proven as a leaf of its own.
+func VerifyProof(root, leaf []byte, proof *Proof) bool {
+	if proof.Index < 0 || proof.Index >= proof.Leaves {
+		return false
+	}
+
+	hash := sha256.Sum256(leaf)
+	index, size := proof.Index, proof.Leaves
+	for level, sibling := range proof.Hashes {
+		// Every level, including a single leaf, is paired into the next.
+		if size == 1 && level > 0 {
+			return false
+		}
+
+		padded := index == size-1 && size%2 != 0
+		if padded != bytes.Equal(hash[:], sibling) {
+			return false
+		}
+
+		if index&1 == 0 {
+			hash = sha256.Sum256(append(hash[:], sibling...))
+		} else {
+			hash = sha256.Sum256(append(sibling[:len(sibling):len(sibling)], hash[:]...))
+		}
+
+		index, size = index/2, (size+1)/2
+	}
+
+	return size == 1 && len(proof.Hashes) > 0 && bytes.Equal(hash[:], root)
+}
@@ -0,0 +1,121 @@
+package merkle_test
+
+import (
+	"amdzy/gochain/pkg/merkle"
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func newTree(t *testing.T, data [][]byte) *merkle.MerkleTree {
+	tree, err := merkle.NewMerkleTree(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestProof(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 7} {
+		t.Run(fmt.Sprintf("%d leaves", n), func(t *testing.T) {
+			data := txLeaves(n)
+			tree := newTree(t, data)
+			root := tree.RootNode.Data
+
+			for i, leaf := range data {
+				proof, err := tree.Proof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !merkle.VerifyProof(root, leaf, proof) {
+					t.Errorf("proof of leaf %d does not verify", i)
+				}
+
+				other := data[(i+1)%n]
+				if !bytes.Equal(other, leaf) && merkle.VerifyProof(root, other, proof) {
+					t.Errorf("proof of leaf %d verifies another leaf", i)
+				}
+
+				// Moving the proof to another position breaks it.
+				for index := 0; index < n; index++ {
+					moved := *proof
+					moved.Index = index
+					if index != i && merkle.VerifyProof(root, leaf, &moved) {
+						t.Errorf("proof of leaf %d verifies at index %d", i, index)
+					}
+				}
+
+				for level := range proof.Hashes {
+					tampered := *proof
+					tampered.Hashes = slices.Clone(proof.Hashes)
+					tampered.Hashes[level] = bytes.Clone(proof.Hashes[level])
+					tampered.Hashes[level][0] ^= 0x01
+
+					if merkle.VerifyProof(root, leaf, &tampered) {
+						t.Errorf("proof of leaf %d verifies with sibling %d tampered", i, level)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProofBadIndex(t *testing.T) {
+	data := txLeaves(3)
+	tree := newTree(t, data)
+	root := tree.RootNode.Data
+
+	for _, index := range []int{-1, 3, 4} {
+		_, err := tree.Proof(index)
+		if !errors.Is(err, merkle.ErrIndexOutOfRange) {
+			t.Errorf("index %d: got %v, want %v", index, err, merkle.ErrIndexOutOfRange)
+		}
+	}
+
+	proof, err := tree.Proof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		index  int
+		leaves int
+	}{
+		{"negative index", -1, 3},
+		{"padding leaf", 3, 3},
+		// The padding leaf passed off as a leaf of a tree of four.
+		{"padding leaf of a larger tree", 3, 4},
+		{"beyond the tree", 7, 8},
+		{"no leaves", 2, 0},
+		{"too few leaves for the path", 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := *proof
+			bad.Index, bad.Leaves = tt.index, tt.leaves
+
+			if merkle.VerifyProof(root, data[2], &bad) {
+				t.Fatal("proof verifies")
+			}
+		})
+	}
+
+	// A path longer or shorter than the tree is deep.
+	long := *proof
+	long.Hashes = append(slices.Clone(proof.Hashes), root)
+	if merkle.VerifyProof(root, data[2], &long) {
+		t.Error("proof with an extra sibling verifies")
+	}
+
+	short := *proof
+	short.Hashes = proof.Hashes[:1]
+	if merkle.VerifyProof(root, data[2], &short) {
+		t.Error("proof with a missing sibling verifies")
+	}
+}
@@ -0,0 +1,211 @@
+package params
+
+import "fmt"
+
+// Checkpoint pins the main chain block at Height to the hex encoded Hash.
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+// AssumeUTXO describes a UTXO set snapshot, taken at the block BlockHash at
+// Height, that nodes may start from. UTXOHash is the hex encoded hash of the
+// set, see utxo.UTXOSet.Hash.
+type AssumeUTXO struct {
+	Height    int
+	BlockHash string
+	UTXOHash  string
+}
+
+type ChainParams struct {
+	Name        string
+	Magic       [4]byte
+	DefaultPort string
+
+	AddressVersion byte
+
+	// The block subsidy starts at Subsidy and halves every HalvingInterval
+	// blocks. A HalvingInterval of zero keeps the subsidy constant.
+	Subsidy             int
+	HalvingInterval     int
+	GenesisCoinbaseData string
+
+	// MaxMoney bounds every output value and every sum of values, so that
+	// they cannot overflow.
+	MaxMoney int
+
+	// Coinbase outputs can only be spent CoinbaseMaturity blocks after the
+	// block that created them.
+	CoinbaseMaturity int
+
+	// Difficulty is expressed as the number of leading zero bits required
+	// in a block hash. A RetargetInterval of zero disables retargeting.
+	InitialBits      int
+	MinBits          int
+	MaxBits          int
+	RetargetInterval int
+	TargetBlockTime  int64
+
+	// ProofOfAuthority networks have their blocks signed in turn by the
+	// Authorities public keys instead of mined. The difficulty fields are
+	// unused.
+	ProofOfAuthority bool
+	Authorities      [][]byte
+
+	// GenesisHash is the hex encoded hash of the genesis block, which light
+	// clients require their chain to start from. The networks here have
+	// their genesis block created by createblockchain, so it has to be set
+	// before a light client is used.
+	GenesisHash string
+
+	// Checkpoints, sorted by height, are blocks every node must have on its
+	// main chain. Signatures of blocks known to lead up to a checkpoint,
+	// from headers received ahead of them, are not verified.
+	Checkpoints []Checkpoint
+
+	// AssumeUTXO lists the snapshots loadtxoutset accepts.
+	AssumeUTXO []AssumeUTXO
+}
+
+var MainNet = ChainParams{
+	Name:        "mainnet",
+	Magic:       [4]byte{0x67, 0x63, 0x68, 0x6e},
+	DefaultPort: "6000",
+
+	AddressVersion: 0x00,
+
+	Subsidy:             10,
+	HalvingInterval:     1000,
+	GenesisCoinbaseData: "gochain mainnet genesis block",
+
+	MaxMoney: 21_000_000,
+
+	CoinbaseMaturity: 10,
+
+	InitialBits:      15,
+	MinBits:          8,
+	MaxBits:          32,
+	RetargetInterval: 10,
+	TargetBlockTime:  10,
+}
+
+var TestNet = ChainParams{
+	Name:        "testnet",
+	Magic:       [4]byte{0x67, 0x63, 0x74, 0x6e},
+	DefaultPort: "16000",
+
+	AddressVersion: 0x6f,
+
+	Subsidy:             10,
+	HalvingInterval:     500,
+	GenesisCoinbaseData: "gochain testnet genesis block",
+
+	MaxMoney: 21_000_000,
+
+	CoinbaseMaturity: 10,
+
+	InitialBits:      12,
+	MinBits:          8,
+	MaxBits:          32,
+	RetargetInterval: 10,
+	TargetBlockTime:  10,
+}
+
+var RegTest = ChainParams{
+	Name:        "regtest",
+	Magic:       [4]byte{0x67, 0x63, 0x72, 0x74},
+	DefaultPort: "26000",
+
+	AddressVersion: 0x6f,
+
+	Subsidy:             10,
+	HalvingInterval:     150,
+	GenesisCoinbaseData: "gochain regtest genesis block",
+
+	MaxMoney: 21_000_000,
+
+	CoinbaseMaturity: 2,
+
+	InitialBits:      4,
+	MinBits:          4,
+	MaxBits:          4,
+	RetargetInterval: 0,
+	TargetBlockTime:  10,
+}
+
+// PoATest is a proof of authority test network. Its authorities are not
+// fixed and have to be supplied before use.
+var PoATest = ChainParams{
+	Name:        "poatest",
+	Magic:       [4]byte{0x67, 0x63, 0x70, 0x61},
+	DefaultPort: "36000",
+
+	AddressVersion: 0x6f,
+
+	Subsidy:             10,
+	HalvingInterval:     0,
+	GenesisCoinbaseData: "gochain poatest genesis block",
+
+	MaxMoney: 21_000_000,
+
+	CoinbaseMaturity: 2,
+
+	ProofOfAuthority: true,
+}
+
+func (p *ChainParams) BlockSubsidy(height int) int {
+	if p.HalvingInterval == 0 {
+		return p.Subsidy
+	}
+
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.Subsidy >> halvings
+}
+
+// CheckpointAt returns the checkpoint at height, if there is one.
+func (p *ChainParams) CheckpointAt(height int) (Checkpoint, bool) {
+	for _, checkpoint := range p.Checkpoints {
+		if checkpoint.Height == height {
+			return checkpoint, true
+		}
+	}
+
+	return Checkpoint{}, false
+}
+
+// LastCheckpoint returns the highest checkpoint at or below height, or false
+// if there is none.
+func (p *ChainParams) LastCheckpoint(height int) (Checkpoint, bool) {
+	for i := len(p.Checkpoints) - 1; i >= 0; i-- {
+		if p.Checkpoints[i].Height <= height {
+			return p.Checkpoints[i], true
+		}
+	}
+
+	return Checkpoint{}, false
+}
+
+// AssumeUTXOAt returns the trusted snapshot at height, if there is one.
+func (p *ChainParams) AssumeUTXOAt(height int) (AssumeUTXO, bool) {
+	for _, snapshot := range p.AssumeUTXO {
+		if snapshot.Height == height {
+			return snapshot, true
+		}
+	}
+
+	return AssumeUTXO{}, false
+}
+
+func ByName(name string) (*ChainParams, error) {
+	for _, p := range []*ChainParams{&MainNet, &TestNet, &RegTest, &PoATest} {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown network %q", name)
+}
@@ -0,0 +1,483 @@
+package script
+
+import (
+	"amdzy/gochain/pkg/wallet"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+)
+
+// maxNumSize is the largest size, in bytes, of a number arithmetic accepts.
+// Results may be larger, but cannot be used as operands again.
+const maxNumSize = 4
+
+// SigChecker checks the signatures of CHECKSIG and CHECKMULTISIG against the
+// transaction input being verified.
+type SigChecker interface {
+	CheckSig(signature, pubKey []byte) bool
+}
+
+// Execute runs scriptSig and then scriptPubKey on the stack it leaves. It
+// succeeds if scriptPubKey finishes with true on top of the stack. scriptSig
+// may only push data.
+func Execute(scriptSig, scriptPubKey []byte, checker SigChecker) error {
+	if len(scriptSig) > MaxScriptSize {
+		return ErrScriptTooLarge
+	}
+
+	_, err := PushedData(scriptSig)
+	if err != nil {
+		return err
+	}
+
+	e := engine{checker: checker}
+
+	err = e.run(scriptSig)
+	if err != nil {
+		return err
+	}
+
+	err = e.run(scriptPubKey)
+	if err != nil {
+		return err
+	}
+
+	if len(e.stack) == 0 || !asBool(e.stack[len(e.stack)-1]) {
+		return ErrVerifyFailed
+	}
+
+	return nil
+}
+
+type engine struct {
+	stack   [][]byte
+	checker SigChecker
+	ops     int
+}
+
+func (e *engine) run(script []byte) error {
+	if len(script) > MaxScriptSize {
+		return ErrScriptTooLarge
+	}
+
+	instructions, err := parse(script)
+	if err != nil {
+		return err
+	}
+
+	// conditions holds, for each enclosing IF, whether its branch is taken.
+	var conditions []bool
+	e.ops = 0
+
+	for _, in := range instructions {
+		if len(in.data) > MaxElementSize {
+			return ErrElementTooLarge
+		}
+
+		if in.op > Op16 {
+			e.ops++
+			if e.ops > maxOps {
+				return ErrTooManyOps
+			}
+		}
+
+		executing := !slices.Contains(conditions, false)
+
+		switch in.op {
+		case OpIf, OpNotIf:
+			taken := false
+			if executing {
+				value, err := e.pop()
+				if err != nil {
+					return err
+				}
+				taken = asBool(value) == (in.op == OpIf)
+			}
+			conditions = append(conditions, taken)
+		case OpElse:
+			if len(conditions) == 0 {
+				return ErrUnbalancedConditional
+			}
+			conditions[len(conditions)-1] = !conditions[len(conditions)-1]
+		case OpEndIf:
+			if len(conditions) == 0 {
+				return ErrUnbalancedConditional
+			}
+			conditions = conditions[:len(conditions)-1]
+		default:
+			if !executing {
+				continue
+			}
+
+			err := e.execute(in)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(e.stack) > maxStackSize {
+			return ErrStackOverflow
+		}
+	}
+
+	if len(conditions) != 0 {
+		return ErrUnbalancedConditional
+	}
+
+	return nil
+}
+
+func (e *engine) execute(in instruction) error {
+	if in.isPush() {
+		e.push(pushedValue(in))
+		return nil
+	}
+
+	switch in.op {
+	case OpNop:
+		return nil
+	case OpVerify:
+		return e.verify()
+	case OpReturn:
+		return ErrEarlyReturn
+
+	case OpDrop:
+		_, err := e.pop()
+		return err
+	case OpDup:
+		value, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(value)
+	case OpSwap:
+		a, b, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.push(b)
+		e.push(a)
+
+	case OpEqual, OpEqualVerify:
+		a, b, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.pushBool(bytes.Equal(a, b))
+		if in.op == OpEqualVerify {
+			return e.verify()
+		}
+
+	case Op1Add, Op1Sub, OpNegate, OpAbs, OpNot, Op0NotEqual:
+		return e.unaryOp(in.op)
+	case OpAdd, OpSub, OpBoolAnd, OpBoolOr, OpNumEqual, OpNumEqualVerify, OpNumNotEqual,
+		OpLessThan, OpGreaterThan, OpLessThanOrEqual, OpGreaterThanOrEqual, OpMin, OpMax:
+		return e.binaryOp(in.op)
+	case OpWithin:
+		upper, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		lower, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		x, err := e.popNum()
+		if err != nil {
+			return err
+		}
+		e.pushBool(lower <= x && x < upper)
+
+	case OpSha256:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(value)
+		e.push(hash[:])
+	case OpHash160:
+		value, err := e.pop()
+		if err != nil {
+			return err
+		}
+		hash, err := wallet.HashPubKey(value)
+		if err != nil {
+			return err
+		}
+		e.push(hash)
+
+	case OpCheckSig, OpCheckSigVerify:
+		signature, pubKey, err := e.pop2()
+		if err != nil {
+			return err
+		}
+		e.pushBool(e.checker.CheckSig(signature, pubKey))
+		if in.op == OpCheckSigVerify {
+			return e.verify()
+		}
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if in.op == OpCheckMultiSigVerify {
+			return e.verify()
+		}
+
+	default:
+		return fmt.Errorf("%w: 0x%02x", ErrBadOpcode, in.op)
+	}
+
+	return nil
+}
+
+func (e *engine) unaryOp(op byte) error {
+	x, err := e.popNum()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case Op1Add:
+		e.pushNum(x + 1)
+	case Op1Sub:
+		e.pushNum(x - 1)
+	case OpNegate:
+		e.pushNum(-x)
+	case OpAbs:
+		e.pushNum(max(x, -x))
+	case OpNot:
+		e.pushBool(x == 0)
+	case Op0NotEqual:
+		e.pushBool(x != 0)
+	}
+
+	return nil
+}
+
+func (e *engine) binaryOp(op byte) error {
+	b, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	a, err := e.popNum()
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case OpAdd:
+		e.pushNum(a + b)
+	case OpSub:
+		e.pushNum(a - b)
+	case OpBoolAnd:
+		e.pushBool(a != 0 && b != 0)
+	case OpBoolOr:
+		e.pushBool(a != 0 || b != 0)
+	case OpNumEqual:
+		e.pushBool(a == b)
+	case OpNumEqualVerify:
+		e.pushBool(a == b)
+		return e.verify()
+	case OpNumNotEqual:
+		e.pushBool(a != b)
+	case OpLessThan:
+		e.pushBool(a < b)
+	case OpGreaterThan:
+		e.pushBool(a > b)
+	case OpLessThanOrEqual:
+		e.pushBool(a <= b)
+	case OpGreaterThanOrEqual:
+		e.pushBool(a >= b)
+	case OpMin:
+		e.pushNum(min(a, b))
+	case OpMax:
+		e.pushNum(max(a, b))
+	}
+
+	return nil
+}
+
+// checkMultiSig pops the number of keys, the keys, the number of
+// signatures and the signatures, and pushes whether each signature is by
+// one of the keys, in the same order.
+func (e *engine) checkMultiSig() error {
+	keyCount, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if keyCount < 0 || keyCount > maxMultiSigKeys {
+		return fmt.Errorf("%w: %d keys", ErrBadMultiSig, keyCount)
+	}
+
+	e.ops += int(keyCount)
+	if e.ops > maxOps {
+		return ErrTooManyOps
+	}
+
+	pubKeys, err := e.popN(int(keyCount))
+	if err != nil {
+		return err
+	}
+
+	sigCount, err := e.popNum()
+	if err != nil {
+		return err
+	}
+	if sigCount < 0 || sigCount > keyCount {
+		return fmt.Errorf("%w: %d signatures for %d keys", ErrBadMultiSig, sigCount, keyCount)
+	}
+
+	signatures, err := e.popN(int(sigCount))
+	if err != nil {
+		return err
+	}
+
+	for len(signatures) > 0 && len(signatures) <= len(pubKeys) {
+		if e.checker.CheckSig(signatures[0], pubKeys[0]) {
+			signatures = signatures[1:]
+		}
+		pubKeys = pubKeys[1:]
+	}
+
+	e.pushBool(len(signatures) == 0)
+
+	return nil
+}
+
+func (e *engine) verify() error {
+	value, err := e.pop()
+	if err != nil {
+		return err
+	}
+
+	if !asBool(value) {
+		return ErrVerifyFailed
+	}
+
+	return nil
+}
+
+func (e *engine) push(value []byte) {
+	e.stack = append(e.stack, value)
+}
+
+func (e *engine) pushNum(n int64) {
+	e.push(encodeNum(n))
+}
+
+func (e *engine) pushBool(b bool) {
+	if b {
+		e.push([]byte{1})
+	} else {
+		e.push([]byte{})
+	}
+}
+
+func (e *engine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *engine) pop() ([]byte, error) {
+	value, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+
+	return value, nil
+}
+
+// pop2 pops the top two items, returning the lower one first.
+func (e *engine) pop2() ([]byte, []byte, error) {
+	items, err := e.popN(2)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return items[0], items[1], nil
+}
+
+// popN pops the top n items, in the order they were pushed.
+func (e *engine) popN(n int) ([][]byte, error) {
+	if len(e.stack) < n {
+		return nil, ErrStackUnderflow
+	}
+
+	items := slices.Clone(e.stack[len(e.stack)-n:])
+	e.stack = e.stack[:len(e.stack)-n]
+
+	return items, nil
+}
+
+func (e *engine) popNum() (int64, error) {
+	value, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeNum(value)
+}
+
+// decodeNum reads a little endian number whose top bit is its sign.
+func decodeNum(value []byte) (int64, error) {
+	if len(value) > maxNumSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrNumberOverflow, len(value))
+	}
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	var n int64
+	for i, b := range value {
+		n |= int64(b) << (8 * i)
+	}
+
+	signBit := int64(0x80) << (8 * (len(value) - 1))
+	if n&signBit != 0 {
+		return -(n &^ signBit), nil
+	}
+
+	return n, nil
+}
+
+func encodeNum(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+
+	var value []byte
+	for ; n > 0; n >>= 8 {
+		value = append(value, byte(n))
+	}
+
+	if value[len(value)-1]&0x80 != 0 {
+		value = append(value, 0)
+	}
+	if negative {
+		value[len(value)-1] |= 0x80
+	}
+
+	return value
+}
+
+// asBool is false for any encoding of zero, including negative zero.
+func asBool(value []byte) bool {
+	for i, b := range value {
+		if b != 0 {
+			return i != len(value)-1 || b != 0x80
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,103 @@
+package script
+
+const (
+	Op0         byte = 0x00
+	OpPushData1 byte = 0x4c
+	OpPushData2 byte = 0x4d
+	Op1Negate   byte = 0x4f
+	Op1         byte = 0x51
+	Op16        byte = 0x60
+
+	OpNop    byte = 0x61
+	OpIf     byte = 0x63
+	OpNotIf  byte = 0x64
+	OpElse   byte = 0x67
+	OpEndIf  byte = 0x68
+	OpVerify byte = 0x69
+	OpReturn byte = 0x6a
+
+	OpDrop byte = 0x75
+	OpDup  byte = 0x76
+	OpSwap byte = 0x7c
+
+	OpEqual       byte = 0x87
+	OpEqualVerify byte = 0x88
+
+	Op1Add               byte = 0x8b
+	Op1Sub               byte = 0x8c
+	OpNegate             byte = 0x8f
+	OpAbs                byte = 0x90
+	OpNot                byte = 0x91
+	Op0NotEqual          byte = 0x92
+	OpAdd                byte = 0x93
+	OpSub                byte = 0x94
+	OpBoolAnd            byte = 0x9a
+	OpBoolOr             byte = 0x9b
+	OpNumEqual           byte = 0x9c
+	OpNumEqualVerify     byte = 0x9d
+	OpNumNotEqual        byte = 0x9e
+	OpLessThan           byte = 0x9f
+	OpGreaterThan        byte = 0xa0
+	OpLessThanOrEqual    byte = 0xa1
+	OpGreaterThanOrEqual byte = 0xa2
+	OpMin                byte = 0xa3
+	OpMax                byte = 0xa4
+	OpWithin             byte = 0xa5
+
+	OpSha256              byte = 0xa8
+	OpHash160             byte = 0xa9
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultiSig       byte = 0xae
+	OpCheckMultiSigVerify byte = 0xaf
+)
+
+var opcodeNames = map[byte]string{
+	Op0:         "OP_0",
+	OpPushData1: "OP_PUSHDATA1",
+	OpPushData2: "OP_PUSHDATA2",
+	Op1Negate:   "OP_1NEGATE",
+
+	OpNop:    "OP_NOP",
+	OpIf:     "OP_IF",
+	OpNotIf:  "OP_NOTIF",
+	OpElse:   "OP_ELSE",
+	OpEndIf:  "OP_ENDIF",
+	OpVerify: "OP_VERIFY",
+	OpReturn: "OP_RETURN",
+
+	OpDrop: "OP_DROP",
+	OpDup:  "OP_DUP",
+	OpSwap: "OP_SWAP",
+
+	OpEqual:       "OP_EQUAL",
+	OpEqualVerify: "OP_EQUALVERIFY",
+
+	Op1Add:               "OP_1ADD",
+	Op1Sub:               "OP_1SUB",
+	OpNegate:             "OP_NEGATE",
+	OpAbs:                "OP_ABS",
+	OpNot:                "OP_NOT",
+	Op0NotEqual:          "OP_0NOTEQUAL",
+	OpAdd:                "OP_ADD",
+	OpSub:                "OP_SUB",
+	OpBoolAnd:            "OP_BOOLAND",
+	OpBoolOr:             "OP_BOOLOR",
+	OpNumEqual:           "OP_NUMEQUAL",
+	OpNumEqualVerify:     "OP_NUMEQUALVERIFY",
+	OpNumNotEqual:        "OP_NUMNOTEQUAL",
+	OpLessThan:           "OP_LESSTHAN",
+	OpGreaterThan:        "OP_GREATERTHAN",
+	OpLessThanOrEqual:    "OP_LESSTHANOREQUAL",
+	OpGreaterThanOrEqual: "OP_GREATERTHANOREQUAL",
+	OpMin:                "OP_MIN",
+	OpMax:                "OP_MAX",
+	OpWithin:             "OP_WITHIN",
+
+	OpSha256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckMultiSigVerify: "OP_CHECKMULTISIGVERIFY",
+}
@@ -0,0 +1,210 @@
+// Package script implements the stack based scripts that lock transaction
+// outputs and unlock them. An output's ScriptPubKey states the conditions for
+// spending it, and the spending input's ScriptSig pushes the data, such as
+// signatures, that satisfy them.
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxScriptSize   = 10000
+	MaxElementSize  = 520
+	maxStackSize    = 1000
+	maxOps          = 201
+	maxMultiSigKeys = 20
+)
+
+var (
+	ErrMalformedScript       = errors.New("script is malformed")
+	ErrScriptTooLarge        = errors.New("script is too large")
+	ErrElementTooLarge       = errors.New("pushed data is too large")
+	ErrNotPushOnly           = errors.New("signature script may only push data")
+	ErrBadOpcode             = errors.New("opcode is unknown")
+	ErrTooManyOps            = errors.New("script executes too many opcodes")
+	ErrStackUnderflow        = errors.New("not enough items on the stack")
+	ErrStackOverflow         = errors.New("too many items on the stack")
+	ErrUnbalancedConditional = errors.New("unbalanced conditional")
+	ErrNumberOverflow        = errors.New("number is out of range")
+	ErrBadMultiSig           = errors.New("invalid number of keys or signatures")
+	ErrEarlyReturn           = errors.New("script ended with OP_RETURN")
+	ErrVerifyFailed          = errors.New("script verification failed")
+)
+
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+func (in instruction) isPush() bool {
+	return in.op <= OpPushData2 || in.op == Op1Negate || (in.op >= Op1 && in.op <= Op16)
+}
+
+// parse splits script into its opcodes and the data they push. Unknown
+// opcodes are only an error when they are executed.
+func parse(script []byte) ([]instruction, error) {
+	var instructions []instruction
+
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+
+		var size int
+		switch {
+		case op < OpPushData1:
+			size = int(op)
+		case op == OpPushData1:
+			if i+1 > len(script) {
+				return instructions, ErrMalformedScript
+			}
+			size = int(script[i])
+			i++
+		case op == OpPushData2:
+			if i+2 > len(script) {
+				return instructions, ErrMalformedScript
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			instructions = append(instructions, instruction{op: op})
+			continue
+		}
+
+		if i+size > len(script) {
+			return instructions, ErrMalformedScript
+		}
+
+		instructions = append(instructions, instruction{op, script[i : i+size]})
+		i += size
+	}
+
+	return instructions, nil
+}
+
+func appendPush(script, data []byte) []byte {
+	switch {
+	case len(data) < int(OpPushData1):
+		script = append(script, byte(len(data)))
+	case len(data) <= 0xff:
+		script = append(script, OpPushData1, byte(len(data)))
+	default:
+		script = append(script, OpPushData2)
+		script = binary.LittleEndian.AppendUint16(script, uint16(len(data)))
+	}
+
+	return append(script, data...)
+}
+
+// PushData returns the script that pushes each of items in turn.
+func PushData(items ...[]byte) []byte {
+	script := []byte{}
+	for _, item := range items {
+		script = appendPush(script, item)
+	}
+
+	return script
+}
+
+// PayToPubKeyHash locks an output to the key hashing to pubKeyHash. It is
+// spent with PushData(signature, pubKey).
+func PayToPubKeyHash(pubKeyHash []byte) []byte {
+	script := []byte{OpDup, OpHash160}
+	script = appendPush(script, pubKeyHash)
+
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// MultiSig locks an output to required signatures from distinct pubKeys. It
+// is spent by pushing the signatures in the order of their keys.
+func MultiSig(required int, pubKeys [][]byte) ([]byte, error) {
+	if len(pubKeys) > 16 || required < 1 || required > len(pubKeys) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrBadMultiSig, required, len(pubKeys))
+	}
+
+	script := []byte{Op1 + byte(required-1)}
+	for _, pubKey := range pubKeys {
+		script = appendPush(script, pubKey)
+	}
+
+	return append(script, Op1+byte(len(pubKeys)-1), OpCheckMultiSig), nil
+}
+
+// ExtractPubKeyHash returns the key hash scriptPubKey pays to if it is a
+// PayToPubKeyHash script, or nil.
+func ExtractPubKeyHash(scriptPubKey []byte) []byte {
+	instructions, err := parse(scriptPubKey)
+	if err != nil || len(instructions) != 5 {
+		return nil
+	}
+
+	if instructions[0].op != OpDup || instructions[1].op != OpHash160 ||
+		instructions[2].op > OpPushData2 || len(instructions[2].data) != 20 ||
+		instructions[3].op != OpEqualVerify || instructions[4].op != OpCheckSig {
+		return nil
+	}
+
+	return instructions[2].data
+}
+
+// PushedData returns the data a script that only pushes data pushes.
+// Small numbers are returned in their stack encoding.
+func PushedData(script []byte) ([][]byte, error) {
+	instructions, err := parse(script)
+	if err != nil {
+		return nil, err
+	}
+
+	var items [][]byte
+	for _, in := range instructions {
+		if !in.isPush() {
+			return nil, ErrNotPushOnly
+		}
+
+		items = append(items, pushedValue(in))
+	}
+
+	return items, nil
+}
+
+func pushedValue(in instruction) []byte {
+	switch {
+	case in.op == Op1Negate:
+		return encodeNum(-1)
+	case in.op >= Op1:
+		return encodeNum(int64(in.op-Op1) + 1)
+	default:
+		return in.data
+	}
+}
+
+// Disassemble formats script as opcode names and hex encoded data.
+func Disassemble(script []byte) string {
+	instructions, err := parse(script)
+
+	var words []string
+	for _, in := range instructions {
+		switch {
+		case in.op == Op0 || in.op == Op1Negate:
+			words = append(words, opcodeNames[in.op])
+		case in.op >= Op1 && in.op <= Op16:
+			words = append(words, fmt.Sprintf("OP_%d", in.op-Op1+1))
+		case in.op <= OpPushData2:
+			words = append(words, hex.EncodeToString(in.data))
+		case opcodeNames[in.op] != "":
+			words = append(words, opcodeNames[in.op])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN(0x%02x)", in.op))
+		}
+	}
+
+	if err != nil {
+		words = append(words, "[malformed]")
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,417 @@
+package script_test
+
+import (
+	"amdzy/gochain/pkg/script"
+	"amdzy/gochain/pkg/wallet"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+)
+
+const signatureLen = 64
+
+// sigHash stands in for the signature hash of the input being verified.
+var sigHash = sha256.Sum256([]byte("input"))
+
+// checker verifies signatures over sigHash, as encoded by sign.
+type checker struct{}
+
+func (checker) CheckSig(signature, pubKey []byte) bool {
+	if len(signature) != signatureLen {
+		return false
+	}
+
+	key, err := wallet.DecodePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(signature[:signatureLen/2])
+	s := new(big.Int).SetBytes(signature[signatureLen/2:])
+
+	return ecdsa.Verify(key, sigHash[:], r, s)
+}
+
+func newWallets(t *testing.T, n int) []*wallet.Wallet {
+	var wallets []*wallet.Wallet
+	for i := 0; i < n; i++ {
+		w, err := wallet.NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallets = append(wallets, w)
+	}
+
+	return wallets
+}
+
+func sign(t *testing.T, w *wallet.Wallet) []byte {
+	r, s, err := ecdsa.Sign(rand.Reader, &w.PrivateKey, sigHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := make([]byte, signatureLen)
+	r.FillBytes(signature[:signatureLen/2])
+	s.FillBytes(signature[signatureLen/2:])
+
+	return signature
+}
+
+func TestPayToPubKeyHash(t *testing.T) {
+	wallets := newWallets(t, 2)
+	owner, other := wallets[0], wallets[1]
+
+	pubKeyHash, err := wallet.HashPubKey(owner.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scriptPubKey := script.PayToPubKeyHash(pubKeyHash)
+
+	if got := script.ExtractPubKeyHash(scriptPubKey); !bytes.Equal(got, pubKeyHash) {
+		t.Fatalf("ExtractPubKeyHash = %x, want %x", got, pubKeyHash)
+	}
+
+	tests := []struct {
+		name      string
+		scriptSig []byte
+		want      error
+	}{
+		{"owner", script.PushData(sign(t, owner), owner.PublicKey), nil},
+		{"other key", script.PushData(sign(t, other), other.PublicKey), script.ErrVerifyFailed},
+		{"other signature", script.PushData(sign(t, other), owner.PublicKey), script.ErrVerifyFailed},
+		{"no signature", script.PushData(owner.PublicKey), script.ErrStackUnderflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := script.Execute(tt.scriptSig, scriptPubKey, checker{})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiSig(t *testing.T) {
+	wallets := newWallets(t, 3)
+
+	var pubKeys [][]byte
+	var signatures [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+		signatures = append(signatures, sign(t, w))
+	}
+
+	scriptPubKey, err := script.MultiSig(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		sigs []int
+		want error
+	}{
+		{"first and second", []int{0, 1}, nil},
+		{"first and third", []int{0, 2}, nil},
+		{"second and third", []int{1, 2}, nil},
+		{"out of order", []int{2, 0}, script.ErrVerifyFailed},
+		{"same key twice", []int{0, 0}, script.ErrVerifyFailed},
+		{"one signature", []int{0}, script.ErrStackUnderflow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sigs [][]byte
+			for _, i := range tt.sigs {
+				sigs = append(sigs, signatures[i])
+			}
+
+			err := script.Execute(script.PushData(sigs...), scriptPubKey, checker{})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	for _, required := range []int{0, 4} {
+		_, err := script.MultiSig(required, pubKeys)
+		if !errors.Is(err, script.ErrBadMultiSig) {
+			t.Errorf("%d of %d: got %v, want %v", required, len(pubKeys), err, script.ErrBadMultiSig)
+		}
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	tests := []struct {
+		name         string
+		scriptSig    []byte
+		scriptPubKey []byte
+	}{
+		{"dup", nil, []byte{script.OpDup}},
+		{"drop", nil, []byte{script.OpDrop, script.Op1}},
+		{"swap", []byte{script.Op1}, []byte{script.OpSwap}},
+		{"equal", []byte{script.Op1}, []byte{script.OpEqual}},
+		{"add", []byte{script.Op1}, []byte{script.OpAdd}},
+		{"within", []byte{script.Op1, script.Op1}, []byte{script.OpWithin}},
+		{"verify", nil, []byte{script.OpVerify, script.Op1}},
+		{"if", nil, []byte{script.OpIf, script.OpEndIf, script.Op1}},
+		{"checksig", script.PushData([]byte{1}), []byte{script.OpCheckSig}},
+		{"checkmultisig keys", nil, []byte{script.Op1, script.OpCheckMultiSig}},
+		{"checkmultisig count", nil, []byte{script.Op0, script.OpCheckMultiSig}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := script.Execute(tt.scriptSig, tt.scriptPubKey, checker{})
+			if !errors.Is(err, script.ErrStackUnderflow) {
+				t.Fatalf("got %v, want %v", err, script.ErrStackUnderflow)
+			}
+		})
+	}
+}
+
+func TestBadOpcodes(t *testing.T) {
+	tests := []struct {
+		name string
+		op   byte
+	}{
+		// Opcodes disabled in Bitcoin are not implemented either.
+		{"OP_CAT", 0x7e},
+		{"OP_SUBSTR", 0x7f},
+		{"OP_MUL", 0x95},
+		{"OP_LSHIFT", 0x98},
+		{"OP_RESERVED", 0x50},
+		{"unassigned", 0xba},
+		{"OP_INVALIDOPCODE", 0xff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scriptSig := []byte{script.Op1, script.Op1}
+
+			err := script.Execute(scriptSig, []byte{tt.op, script.Op1}, checker{})
+			if !errors.Is(err, script.ErrBadOpcode) {
+				t.Fatalf("got %v, want %v", err, script.ErrBadOpcode)
+			}
+
+			// Only executing the opcode fails.
+			err = script.Execute(scriptSig, []byte{script.Op0, script.OpIf, tt.op, script.OpEndIf}, checker{})
+			if err != nil {
+				t.Fatalf("opcode in a branch not taken: %v", err)
+			}
+		})
+	}
+}
+
+func TestMalformedPushData(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short push", []byte{5, 1, 2}},
+		{"pushdata1 without length", []byte{script.OpPushData1}},
+		{"short pushdata1", []byte{script.OpPushData1, 3, 1, 2}},
+		{"pushdata2 without length", []byte{script.OpPushData2, 1}},
+		{"short pushdata2", []byte{script.OpPushData2, 0, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := script.Execute(tt.data, []byte{script.Op1}, checker{})
+			if !errors.Is(err, script.ErrMalformedScript) {
+				t.Fatalf("in scriptSig: got %v, want %v", err, script.ErrMalformedScript)
+			}
+
+			err = script.Execute(nil, append([]byte{script.Op1}, tt.data...), checker{})
+			if !errors.Is(err, script.ErrMalformedScript) {
+				t.Fatalf("in scriptPubKey: got %v, want %v", err, script.ErrMalformedScript)
+			}
+		})
+	}
+
+	err := script.Execute(script.PushData(make([]byte, script.MaxElementSize+1)), []byte{script.Op1}, checker{})
+	if !errors.Is(err, script.ErrElementTooLarge) {
+		t.Fatalf("got %v, want %v", err, script.ErrElementTooLarge)
+	}
+
+	err = script.Execute([]byte{script.Op1, script.OpDup}, []byte{script.OpEqual}, checker{})
+	if !errors.Is(err, script.ErrNotPushOnly) {
+		t.Fatalf("got %v, want %v", err, script.ErrNotPushOnly)
+	}
+}
+
+// num returns the script pushing n.
+func num(n int64) []byte {
+	switch {
+	case n == 0:
+		return []byte{script.Op0}
+	case n == -1:
+		return []byte{script.Op1Negate}
+	case n >= 1 && n <= 16:
+		return []byte{script.Op1 + byte(n-1)}
+	}
+
+	magnitude := n
+	if n < 0 {
+		magnitude = -n
+	}
+
+	var value []byte
+	for ; magnitude > 0; magnitude >>= 8 {
+		value = append(value, byte(magnitude))
+	}
+	if value[len(value)-1]&0x80 != 0 {
+		value = append(value, 0)
+	}
+	if n < 0 {
+		value[len(value)-1] |= 0x80
+	}
+
+	return script.PushData(value)
+}
+
+// nums returns the script pushing each of ns.
+func nums(ns ...int64) []byte {
+	var code []byte
+	for _, n := range ns {
+		code = append(code, num(n)...)
+	}
+
+	return code
+}
+
+// expectStack appends to code a check that the stack ends with want, the
+// top item last.
+func expectStack(code []byte, want ...int64) []byte {
+	code = slices.Clone(code)
+	for i := len(want) - 1; i > 0; i-- {
+		code = append(append(code, num(want[i])...), script.OpNumEqualVerify)
+	}
+
+	return append(append(code, num(want[0])...), script.OpNumEqual)
+}
+
+// The small number opcodes follow each other from OP_1.
+const (
+	op2 = script.Op1 + iota + 1
+	op3
+	op4
+	op5
+)
+
+func TestConditionals(t *testing.T) {
+	ifElse := []byte{script.OpIf, op2, script.OpElse, op3, script.OpEndIf}
+	notIfElse := []byte{script.OpNotIf, op2, script.OpElse, op3, script.OpEndIf}
+	// Each ELSE switches between the branches of its IF.
+	twoElses := []byte{script.OpIf, op2, script.OpElse, op3, script.OpElse, op4, script.OpEndIf}
+	// The inner IF pops its condition only if the outer branch runs.
+	nested := []byte{
+		script.OpIf,
+		script.OpIf, op2, script.OpElse, op3, script.OpEndIf,
+		script.OpElse,
+		script.OpIf, op4, script.OpElse, op5, script.OpEndIf,
+		script.OpEndIf,
+	}
+
+	tests := []struct {
+		name      string
+		scriptSig []byte
+		code      []byte
+		want      []int64
+	}{
+		{"if true", nums(1), ifElse, []int64{2}},
+		{"if false", nums(0), ifElse, []int64{3}},
+		{"if negative", nums(-1), ifElse, []int64{2}},
+		{"if empty bytes", script.PushData([]byte{}), ifElse, []int64{3}},
+		{"notif true", nums(1), notIfElse, []int64{3}},
+		{"notif false", nums(0), notIfElse, []int64{2}},
+		{"two elses true", nums(1), twoElses, []int64{2, 4}},
+		{"two elses false", nums(0), twoElses, []int64{3}},
+		{"nested true true", nums(1, 1), nested, []int64{2}},
+		{"nested false true", nums(0, 1), nested, []int64{3}},
+		{"nested true false", nums(1, 0), nested, []int64{4}},
+		{"nested false false", nums(0, 0), nested, []int64{5}},
+		{"nested else leaves the stack", nums(7, 0, 0), nested, []int64{7, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := script.Execute(tt.scriptSig, expectStack(tt.code, tt.want...), checker{})
+			if err != nil {
+				t.Fatalf("want %v: %v", tt.want, err)
+			}
+
+			wrong := slices.Clone(tt.want)
+			wrong[len(wrong)-1]++
+			err = script.Execute(tt.scriptSig, expectStack(tt.code, wrong...), checker{})
+			if !errors.Is(err, script.ErrVerifyFailed) {
+				t.Fatalf("want %v: got %v, want %v", wrong, err, script.ErrVerifyFailed)
+			}
+		})
+	}
+
+	for _, code := range [][]byte{
+		{script.Op1, script.OpIf},
+		{script.OpElse, script.Op1},
+		{script.Op1, script.OpEndIf},
+		{script.Op1, script.Op1, script.OpIf, script.OpIf, script.OpEndIf},
+	} {
+		err := script.Execute(nil, code, checker{})
+		if !errors.Is(err, script.ErrUnbalancedConditional) {
+			t.Errorf("%x: got %v, want %v", code, err, script.ErrUnbalancedConditional)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		want int64
+	}{
+		{"add", append(nums(2, 3), script.OpAdd), 5},
+		{"add multibyte", append(nums(200, 100), script.OpAdd), 300},
+		{"sub", append(nums(2, 5), script.OpSub), -3},
+		{"sub negative", append(nums(-200, -300), script.OpSub), 100},
+		{"1add", append(nums(-1), script.Op1Add), 0},
+		{"1sub", append(nums(0), script.Op1Sub), -1},
+		{"negate", append(nums(7), script.OpNegate), -7},
+		{"abs", append(nums(-128), script.OpAbs), 128},
+		{"not zero", append(nums(0), script.OpNot), 1},
+		{"not nonzero", append(nums(5), script.OpNot), 0},
+		{"0notequal", append(nums(-5), script.Op0NotEqual), 1},
+		{"booland", append(nums(3, 0), script.OpBoolAnd), 0},
+		{"boolor", append(nums(0, 3), script.OpBoolOr), 1},
+		{"numequal", append(nums(300, 300), script.OpNumEqual), 1},
+		{"numnotequal", append(nums(300, 300), script.OpNumNotEqual), 0},
+		{"lessthan", append(nums(-2, 3), script.OpLessThan), 1},
+		{"greaterthan", append(nums(-2, 3), script.OpGreaterThan), 0},
+		{"lessthanorequal", append(nums(3, 3), script.OpLessThanOrEqual), 1},
+		{"greaterthanorequal", append(nums(2, 3), script.OpGreaterThanOrEqual), 0},
+		{"min", append(nums(200, -5), script.OpMin), -5},
+		{"max", append(nums(200, -5), script.OpMax), 200},
+		{"within", append(nums(2, 2, 5), script.OpWithin), 1},
+		{"within upper bound", append(nums(5, 2, 5), script.OpWithin), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := script.Execute(nil, expectStack(tt.code, tt.want), checker{})
+			if err != nil {
+				t.Fatalf("want %d: %v", tt.want, err)
+			}
+
+			err = script.Execute(nil, expectStack(tt.code, tt.want+1), checker{})
+			if !errors.Is(err, script.ErrVerifyFailed) {
+				t.Fatalf("want %d: got %v, want %v", tt.want+1, err, script.ErrVerifyFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/utxo"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const historyProgressInterval = 100
+
+// historyRequestTimeout is how long to wait for a historical block before
+// asking again, possibly another peer.
+const historyRequestTimeout = time.Minute
+
+// historySync downloads the blocks below a loaded UTXO snapshot from full
+// nodes, one at a time, and validates them in the background.
+type historySync struct {
+	mu        sync.Mutex
+	utxoSet   utxo.UTXOSet
+	wanted    []byte
+	peer      string
+	requested time.Time
+	done      bool
+}
+
+// newHistorySync returns nil if there is no snapshot left to validate. It
+// fails if validation already proved the snapshot invalid, the node must not
+// run on it.
+func newHistorySync(utxoSet utxo.UTXOSet) (*historySync, error) {
+	status, err := utxoSet.SnapshotStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	if status != nil && status.Failure != "" {
+		return nil, fmt.Errorf("%w: %s, remove the data directory and sync again", utxo.ErrSnapshotInvalid, status.Failure)
+	}
+
+	if status == nil || status.Validated {
+		return nil, nil
+	}
+
+	fmt.Printf("Validating history below the utxo snapshot at height %d, next block %d\n", status.Height, status.NextHeight)
+
+	return &historySync{utxoSet: utxoSet}, nil
+}
+
+// requestNext asks addr for the next historical block unless a request is
+// already outstanding and has not timed out.
+func (h *historySync) requestNext(addr string) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done || (h.wanted != nil && time.Since(h.requested) < historyRequestTimeout) {
+		return nil
+	}
+
+	return h.request(addr)
+}
+
+func (h *historySync) request(addr string) error {
+	status, err := h.utxoSet.SnapshotStatus()
+	if err != nil {
+		return err
+	}
+
+	header, err := h.utxoSet.Blockchain.GetBlockByHeight(status.NextHeight)
+	if err != nil {
+		return err
+	}
+	h.wanted = header.Hash
+	h.peer = addr
+	h.requested = time.Now()
+
+	return sendGetData(addr, "block", header.Hash)
+}
+
+// watch asks the last peer again for a historical block it has not
+// delivered in time, until history is validated.
+func (h *historySync) watch() {
+	ticker := time.NewTicker(historyRequestTimeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mu.Lock()
+		peer, done := h.peer, h.done
+		h.mu.Unlock()
+
+		if done {
+			return
+		}
+
+		if peer != "" {
+			err := h.requestNext(peer)
+			if err != nil {
+				fmt.Printf("Could not request historical block from %s: %v\n", peer, err)
+			}
+		}
+	}
+}
+
+// handleBlock reports whether block was the historical block being waited
+// for, in which case it has been validated and the next one requested.
+func (h *historySync) handleBlock(block *blockchain.Block, addrFrom string) (bool, error) {
+	if h == nil {
+		return false, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done || !bytes.Equal(block.Hash, h.wanted) {
+		return false, nil
+	}
+	h.wanted = nil
+
+	status, err := h.utxoSet.ValidateSnapshotBlock(block)
+	if errors.Is(err, utxo.ErrSnapshotInvalid) {
+		log.Fatalf("Background validation failed, the utxo snapshot is invalid: %v", err)
+	}
+	if err != nil {
+		// Most likely the peer sent a block that does not match the
+		// header, it is requested again on the next version message.
+		fmt.Printf("Could not validate historical block at height %d: %v\n", block.Height, err)
+
+		return true, err
+	}
+
+	if status.Validated {
+		h.done = true
+		fmt.Printf("History validated, the utxo snapshot at height %d is correct\n", status.Height)
+
+		return true, nil
+	}
+
+	if status.NextHeight%historyProgressInterval == 0 {
+		fmt.Printf("Validated history up to height %d of %d\n", status.NextHeight-1, status.Height)
+	}
+
+	return true, h.request(addrFrom)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"amdzy/gochain/pkg/transactions"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// txPool holds verified transactions that are not in a block yet. It is
+// shared by the goroutines handling connections. No two transactions in the
+// pool spend the same output, so any of them can go in one block.
+type txPool struct {
+	mu    sync.Mutex
+	txs   map[string]transactions.Transaction
+	spent map[string]string
+}
+
+func newTxPool() *txPool {
+	return &txPool{
+		txs:   make(map[string]transactions.Transaction),
+		spent: make(map[string]string),
+	}
+}
+
+func outpoint(vin transactions.TXInput) string {
+	return fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)
+}
+
+func (p *txPool) get(id []byte) (transactions.Transaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tx, ok := p.txs[hex.EncodeToString(id)]
+
+	return tx, ok
+}
+
+// add adds tx unless it spends an output that a transaction already in the
+// pool spends, and reports whether it did.
+func (p *txPool) add(tx transactions.Transaction) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := p.txs[id]; ok {
+		return true
+	}
+
+	for _, vin := range tx.Vin {
+		if _, ok := p.spent[outpoint(vin)]; ok {
+			return false
+		}
+	}
+
+	for _, vin := range tx.Vin {
+		p.spent[outpoint(vin)] = id
+	}
+	p.txs[id] = tx
+
+	return true
+}
+
+func (p *txPool) remove(id []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := hex.EncodeToString(id)
+	tx, ok := p.txs[key]
+	if !ok {
+		return
+	}
+
+	for _, vin := range tx.Vin {
+		if p.spent[outpoint(vin)] == key {
+			delete(p.spent, outpoint(vin))
+		}
+	}
+	delete(p.txs, key)
+}
+
+func (p *txPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.txs)
+}
+
+// all returns a copy of the pool, so the caller can verify and mine the
+// transactions without holding the lock.
+func (p *txPool) all() []transactions.Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	txs := make([]transactions.Transaction, 0, len(p.txs))
+	for _, tx := range p.txs {
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+// blockQueue holds the hashes of blocks announced by a peer that have not
+// been requested yet.
+type blockQueue struct {
+	mu     sync.Mutex
+	hashes [][]byte
+}
+
+// reset replaces the queue with hashes, except for skip which is requested
+// right away.
+func (q *blockQueue) reset(hashes [][]byte, skip []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.hashes = nil
+	for _, hash := range hashes {
+		if !bytes.Equal(hash, skip) {
+			q.hashes = append(q.hashes, hash)
+		}
+	}
+}
+
+func (q *blockQueue) next() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.hashes) == 0 {
+		return nil, false
+	}
+
+	hash := q.hashes[0]
+	q.hashes = q.hashes[1:]
+
+	return hash, true
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"amdzy/gochain/pkg/transactions"
+	"testing"
+)
+
+func poolTx(id byte, spends ...transactions.TXInput) transactions.Transaction {
+	return transactions.Transaction{ID: []byte{id}, Vin: spends}
+}
+
+func TestTxPoolConflicts(t *testing.T) {
+	pool := newTxPool()
+	a := transactions.TXInput{Txid: []byte{0xa}, Vout: 0}
+	b := transactions.TXInput{Txid: []byte{0xa}, Vout: 1}
+
+	if !pool.add(poolTx(1, a)) {
+		t.Fatal("first spend of an output was rejected")
+	}
+	if !pool.add(poolTx(1, a)) {
+		t.Fatal("adding a transaction again was rejected")
+	}
+	if pool.add(poolTx(2, b, a)) {
+		t.Fatal("second spend of an output was added")
+	}
+	if !pool.add(poolTx(3, b)) {
+		t.Fatal("spend of another output of the same transaction was rejected")
+	}
+	if pool.count() != 2 {
+		t.Fatalf("pool holds %d transactions, want 2", pool.count())
+	}
+
+	// Once the first spend leaves the pool, the output can be spent again.
+	pool.remove([]byte{1})
+	if !pool.add(poolTx(4, a)) {
+		t.Fatal("spend of an output no longer spent in the pool was rejected")
+	}
+	if pool.add(poolTx(5, b)) {
+		t.Fatal("second spend of an output was added")
+	}
+}
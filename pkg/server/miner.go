@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// miningJob tracks the block currently being mined so it can be abandoned
+// as soon as a new tip arrives from the network.
+type miningJob struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func (j *miningJob) start() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	j.mu.Lock()
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	return ctx, func() {
+		j.mu.Lock()
+		j.cancel = nil
+		j.mu.Unlock()
+
+		cancel()
+	}
+}
+
+func (j *miningJob) abort() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.cancel != nil {
+		j.cancel()
+		j.cancel = nil
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const maxOrphanBlocks = 100
+const orphanExpiry = 20 * time.Minute
+
+type orphanBlock struct {
+	block      *blockchain.Block
+	expiration time.Time
+}
+
+// orphanPool holds blocks whose parent has not been received yet, indexed
+// by the missing parent so they can be connected once it arrives.
+type orphanPool struct {
+	mu       sync.Mutex
+	orphans  map[string]*orphanBlock
+	byParent map[string][]*orphanBlock
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		orphans:  make(map[string]*orphanBlock),
+		byParent: make(map[string][]*orphanBlock),
+	}
+}
+
+func (p *orphanPool) add(block *blockchain.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash := hex.EncodeToString(block.Hash)
+	if _, ok := p.orphans[hash]; ok {
+		return
+	}
+
+	p.expire()
+
+	if len(p.orphans) >= maxOrphanBlocks {
+		var oldest *orphanBlock
+		for _, orphan := range p.orphans {
+			if oldest == nil || orphan.expiration.Before(oldest.expiration) {
+				oldest = orphan
+			}
+		}
+		p.remove(oldest)
+	}
+
+	orphan := &orphanBlock{block, time.Now().Add(orphanExpiry)}
+	parent := hex.EncodeToString(block.PrevBlockHash)
+
+	p.orphans[hash] = orphan
+	p.byParent[parent] = append(p.byParent[parent], orphan)
+}
+
+// root returns the hash of the missing ancestor at the bottom of the orphan
+// chain that hash belongs to.
+func (p *orphanPool) root(hash []byte) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		orphan, ok := p.orphans[hex.EncodeToString(hash)]
+		if !ok {
+			return hash
+		}
+		hash = orphan.block.PrevBlockHash
+	}
+}
+
+func (p *orphanPool) takeChildren(parentHash []byte) []*blockchain.Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var children []*blockchain.Block
+
+	for _, orphan := range p.byParent[hex.EncodeToString(parentHash)] {
+		children = append(children, orphan.block)
+		p.remove(orphan)
+	}
+
+	return children
+}
+
+func (p *orphanPool) expire() {
+	now := time.Now()
+
+	for _, orphan := range p.orphans {
+		if now.After(orphan.expiration) {
+			p.remove(orphan)
+		}
+	}
+}
+
+func (p *orphanPool) remove(orphan *orphanBlock) {
+	delete(p.orphans, hex.EncodeToString(orphan.block.Hash))
+
+	parent := hex.EncodeToString(orphan.block.PrevBlockHash)
+	siblings := p.byParent[parent]
+	for i, sibling := range siblings {
+		if sibling == orphan {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+
+	if len(siblings) == 0 {
+		delete(p.byParent, parent)
+	} else {
+		p.byParent[parent] = siblings
+	}
+}
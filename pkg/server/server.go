@@ -2,15 +2,23 @@ package server
 
 import (
 	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/gcs"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/spv"
 	"amdzy/gochain/pkg/transactions"
 	"amdzy/gochain/pkg/utxo"
+	"amdzy/gochain/pkg/wallet"
 	"bytes"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"slices"
+	"sync"
+	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -18,12 +26,31 @@ import (
 const protocol = "tcp"
 const nodeVersion = 1
 const commandLength = 12
+const maxHeadersPerMessage = 2000
+const maxFiltersPerRequest = 1000
+
+// Service bits advertised in the version handshake.
+const (
+	// serviceNetwork nodes can serve every block of the main chain.
+	serviceNetwork = 1 << 0
+	// serviceNetworkLimited nodes only serve the last
+	// blockchain.MinPruneDepth blocks.
+	serviceNetworkLimited = 1 << 1
+	// serviceCompactFilters nodes serve compact block filters.
+	serviceCompactFilters = 1 << 2
+)
 
 var nodeAddress string
 var miningAddress string
-var KnownNodes = []string{"localhost:6000"}
-var blocksInTransit = [][]byte{}
-var mempool = make(map[string]transactions.Transaction)
+var chainParams = &params.MainNet
+var KnownNodes = []string{"localhost:" + params.MainNet.DefaultPort}
+var knownNodesMu sync.RWMutex
+var blocksInTransit = &blockQueue{}
+var mempool = newTxPool()
+var orphans = newOrphanPool()
+var timeSource = blockchain.NewMedianTimeSource()
+var mining = &miningJob{}
+var history *historySync
 
 type addr struct {
 	AddrList []string
@@ -38,12 +65,52 @@ type getBlocks struct {
 	AddrFrom string
 }
 
+type getHeaders struct {
+	AddrFrom string
+	Locator  [][]byte
+}
+
+type headers struct {
+	AddrFrom string
+	Headers  [][]byte
+}
+
+type getProofs struct {
+	AddrFrom     string
+	PubKeyHashes [][]byte
+}
+
+type proofs struct {
+	AddrFrom string
+	Proofs   [][]byte
+}
+
+type getCFilters struct {
+	AddrFrom    string
+	StartHeight int
+	StopHash    []byte
+}
+
+type cfilter struct {
+	AddrFrom  string
+	BlockHash []byte
+	Filter    gcs.Filter
+}
+
 type getData struct {
 	AddrFrom string
 	Type     string
 	ID       []byte
 }
 
+// notFound answers a request for something the node does not have, such as
+// the filter of a block pruned before the filter index was built.
+type notFound struct {
+	AddrFrom string
+	Type     string
+	ID       []byte
+}
+
 type inv struct {
 	AddrFrom string
 	Type     string
@@ -59,6 +126,8 @@ type verzion struct {
 	Version    int
 	BestHeight int
 	AddrFrom   string
+	Timestamp  int64
+	Services   uint64
 }
 
 func commandToBytes(command string) []byte {
@@ -83,8 +152,37 @@ func bytesToCommand(bytes []byte) string {
 	return string(command)
 }
 
+// knownNodes returns a copy of KnownNodes, which connection handlers update
+// concurrently. The seed node is always first.
+func knownNodes() []string {
+	knownNodesMu.RLock()
+	defer knownNodesMu.RUnlock()
+
+	return slices.Clone(KnownNodes)
+}
+
+func addKnownNodes(addrs ...string) {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	for _, addr := range addrs {
+		if !slices.Contains(KnownNodes, addr) {
+			KnownNodes = append(KnownNodes, addr)
+		}
+	}
+}
+
+func removeKnownNode(addr string) {
+	knownNodesMu.Lock()
+	defer knownNodesMu.Unlock()
+
+	KnownNodes = slices.DeleteFunc(KnownNodes, func(node string) bool {
+		return node == addr
+	})
+}
+
 func requestBlocks() error {
-	for _, node := range KnownNodes {
+	for _, node := range knownNodes() {
 		err := sendGetBlocks(node)
 		if err != nil {
 			return err
@@ -95,7 +193,7 @@ func requestBlocks() error {
 
 func sendAddr(address string) error {
 	fmt.Println("Sending Addr")
-	nodes := addr{KnownNodes}
+	nodes := addr{knownNodes()}
 	nodes.AddrList = append(nodes.AddrList, nodeAddress)
 	payload, err := msgpack.Marshal(nodes)
 	if err != nil {
@@ -127,21 +225,14 @@ func sendData(addr string, data []byte) error {
 	conn, err := net.Dial(protocol, addr)
 	if err != nil {
 		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-
-		for _, node := range KnownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-
-		KnownNodes = updatedNodes
+		removeKnownNode(addr)
 
 		return nil
 	}
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	message := append(chainParams.Magic[:], data...)
+	_, err = io.Copy(conn, bytes.NewReader(message))
 	return err
 }
 
@@ -167,6 +258,27 @@ func sendGetBlocks(address string) error {
 	return sendData(address, request)
 }
 
+// sendGetHeaders asks for the headers of the main chain of address that
+// follow the first hash of locator it has.
+func sendGetHeaders(address string, locator [][]byte) error {
+	payload, err := msgpack.Marshal(getHeaders{nodeAddress, locator})
+	if err != nil {
+		return err
+	}
+
+	request := append(commandToBytes("getheaders"), payload...)
+
+	return sendData(address, request)
+}
+
+// belowCheckpoint reports whether height is below the last checkpoint, so
+// that syncing headers first lets the blocks up to it skip signature checks.
+func belowCheckpoint(bc *blockchain.Blockchain, height int) bool {
+	checkpoints := bc.Params.Checkpoints
+
+	return len(checkpoints) > 0 && height < checkpoints[len(checkpoints)-1].Height
+}
+
 func sendGetData(address, kind string, id []byte) error {
 	payload, err := msgpack.Marshal(getData{nodeAddress, kind, id})
 	if err != nil {
@@ -201,7 +313,12 @@ func sendVersion(addr string, bc *blockchain.Blockchain) error {
 		return err
 	}
 
-	payload, err := msgpack.Marshal(verzion{nodeVersion, bestHeight, nodeAddress})
+	services, err := localServices(bc)
+	if err != nil {
+		return err
+	}
+
+	payload, err := msgpack.Marshal(verzion{nodeVersion, bestHeight, nodeAddress, time.Now().Unix(), services})
 	if err != nil {
 		return err
 	}
@@ -221,11 +338,10 @@ func handleAddr(request []byte) error {
 		return err
 	}
 
-	KnownNodes = append(KnownNodes, payload.AddrList...)
-	slices.Sort(KnownNodes)
-	KnownNodes = slices.Compact(KnownNodes)
-	fmt.Printf("There are %d known nodes now!\n", len(KnownNodes))
-	fmt.Println(KnownNodes)
+	addKnownNodes(payload.AddrList...)
+	nodes := knownNodes()
+	fmt.Printf("There are %d known nodes now!\n", len(nodes))
+	fmt.Println(nodes)
 	return requestBlocks()
 }
 
@@ -246,24 +362,78 @@ func handleBlock(request []byte, bc *blockchain.Blockchain) error {
 	}
 
 	fmt.Println("Received a new block!")
-	bc.AddBlock(block)
-
-	fmt.Printf("Added block %x\n", block.Hash)
+	err = processBlock(block, payload.AddrFrom, bc)
+	if err != nil {
+		fmt.Printf("Rejected block %x: %v\n", block.Hash, err)
+		return err
+	}
 
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
+	blockHash, ok := blocksInTransit.next()
+	if ok {
 		err := sendGetData(payload.AddrFrom, "block", blockHash)
 		if err != nil {
 			return err
 		}
+	}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := utxo.UTXOSet{Blockchain: bc}
-		err := UTXOSet.ReIndex()
+	return nil
+}
+
+func processBlock(block *blockchain.Block, addrFrom string, bc *blockchain.Blockchain) error {
+	handled, err := history.handleBlock(block, addrFrom)
+	if handled {
+		return err
+	}
+
+	oldTip, _, err := bc.Db.GetLastHashAndHeight()
+	if err != nil {
+		return err
+	}
+
+	err = bc.AddBlock(block)
+	if errors.Is(err, blockchain.ErrUnknownParent) {
+		err := bc.CheckOrphan(block)
 		if err != nil {
 			return err
 		}
+
+		orphans.add(block)
+
+		missing := orphans.root(block.Hash)
+		fmt.Printf("Block %x is an orphan, requesting %x\n", block.Hash, missing)
+
+		return sendGetData(addrFrom, "block", missing)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added block %x\n", block.Hash)
+
+	parents := [][]byte{block.Hash}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		for _, orphan := range orphans.takeChildren(parent) {
+			err := bc.AddBlock(orphan)
+			if err != nil {
+				fmt.Printf("Rejected orphan block %x: %v\n", orphan.Hash, err)
+				continue
+			}
+
+			fmt.Printf("Added orphan block %x\n", orphan.Hash)
+			parents = append(parents, orphan.Hash)
+		}
+	}
+
+	newTip, _, err := bc.Db.GetLastHashAndHeight()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(oldTip, newTip) {
+		mining.abort()
 	}
 
 	return nil
@@ -282,27 +452,19 @@ func handleInv(request []byte) error {
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
 
 	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
 		blockHash := payload.Items[0]
+		blocksInTransit.reset(payload.Items, blockHash)
+
 		err := sendGetData(payload.AddrFrom, "block", blockHash)
 		if err != nil {
 			return err
 		}
-
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if !bytes.Equal(b, blockHash) {
-				newInTransit = append(newInTransit, b)
-			}
-		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
-		if mempool[hex.EncodeToString(txID)].ID == nil {
+		if _, ok := mempool.get(txID); !ok {
 			err := sendGetData(payload.AddrFrom, "tx", txID)
 			if err != nil {
 				return err
@@ -327,10 +489,238 @@ func handleGetBlocks(request []byte, bc *blockchain.Blockchain) error {
 	if err != nil {
 		return err
 	}
+	slices.Reverse(blocks)
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return err
+	}
+
+	if pruneHeight > 0 {
+		blocks = blocks[pruneHeight+1:]
+	}
 
 	return sendInv(payload.AddrFrom, "block", blocks)
 }
 
+// handleGetHeaders answers a light client with the headers of the main
+// chain that follow the most recent block of the client's locator that is
+// in the main chain, or from genesis if there is none.
+func handleGetHeaders(request []byte, bc *blockchain.Blockchain) error {
+	var buff bytes.Buffer
+	var payload getHeaders
+
+	buff.Write(request[commandLength:])
+	err := msgpack.Unmarshal(buff.Bytes(), &payload)
+	if err != nil {
+		return err
+	}
+
+	start := 0
+	for _, hash := range payload.Locator {
+		block, err := bc.GetBlock(hash)
+		if errors.Is(err, blockchain.ErrBlockNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		mainBlock, err := bc.GetBlockByHeight(block.Height)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(mainBlock.Hash, hash) {
+			start = block.Height + 1
+			break
+		}
+	}
+
+	var items [][]byte
+	bci := bc.ForwardIterator(start)
+	for len(items) < maxHeadersPerMessage {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+
+		block.Transactions = nil
+		headerData, err := block.Serialize()
+		if err != nil {
+			return err
+		}
+		items = append(items, headerData)
+	}
+
+	payloadData, err := msgpack.Marshal(headers{nodeAddress, items})
+	if err != nil {
+		return err
+	}
+
+	return sendData(payload.AddrFrom, append(commandToBytes("headers"), payloadData...))
+}
+
+// handleHeaders stores the headers a peer sent ahead of its blocks, asks for
+// more until they reach the last checkpoint, then asks for the blocks.
+func handleHeaders(request []byte, bc *blockchain.Blockchain) error {
+	var buff bytes.Buffer
+	var payload headers
+
+	buff.Write(request[commandLength:])
+	err := msgpack.Unmarshal(buff.Bytes(), &payload)
+	if err != nil {
+		return err
+	}
+
+	var received []*blockchain.Block
+	for _, headerData := range payload.Headers {
+		header, err := blockchain.DeserializeBlock(headerData)
+		if err != nil {
+			return err
+		}
+		received = append(received, header)
+	}
+
+	err = bc.AddHeaders(received)
+	if err != nil {
+		// The blocks are still fully verified as they arrive.
+		fmt.Printf("Rejected headers from %s: %v\n", payload.AddrFrom, err)
+		return sendGetBlocks(payload.AddrFrom)
+	}
+
+	if len(received) == maxHeadersPerMessage {
+		last := received[len(received)-1]
+		if belowCheckpoint(bc, last.Height) {
+			return sendGetHeaders(payload.AddrFrom, [][]byte{last.Hash})
+		}
+	}
+
+	return sendGetBlocks(payload.AddrFrom)
+}
+
+// handleGetProofs answers a light client with proofs of every transaction
+// of the main chain that pays to or spends from its addresses, as far back
+// as this node still has the blocks.
+func handleGetProofs(request []byte, bc *blockchain.Blockchain) error {
+	var buff bytes.Buffer
+	var payload getProofs
+
+	buff.Write(request[commandLength:])
+	err := msgpack.Unmarshal(buff.Bytes(), &payload)
+	if err != nil {
+		return err
+	}
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return err
+	}
+
+	var items [][]byte
+	bci := bc.ForwardIterator(pruneHeight)
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			break
+		}
+
+		for i, tx := range block.Transactions {
+			if !spv.Touches(tx, payload.PubKeyHashes) {
+				continue
+			}
+
+			proof, err := blockchain.NewTxOutProof(block, i)
+			if err != nil {
+				return err
+			}
+
+			proofData, err := proof.Serialize()
+			if err != nil {
+				return err
+			}
+			items = append(items, proofData)
+		}
+	}
+
+	payloadData, err := msgpack.Marshal(proofs{nodeAddress, items})
+	if err != nil {
+		return err
+	}
+
+	return sendData(payload.AddrFrom, append(commandToBytes("proofs"), payloadData...))
+}
+
+// handleGetCFilters sends a cfilter message for each block of the main
+// chain from the start height up to the stop block, or a notfound message
+// for blocks without a filter.
+func handleGetCFilters(request []byte, bc *blockchain.Blockchain) error {
+	var buff bytes.Buffer
+	var payload getCFilters
+
+	buff.Write(request[commandLength:])
+	err := msgpack.Unmarshal(buff.Bytes(), &payload)
+	if err != nil {
+		return err
+	}
+
+	stop, err := bc.GetBlock(payload.StopHash)
+	if err != nil {
+		return err
+	}
+
+	if stop.Height < payload.StartHeight || stop.Height-payload.StartHeight >= maxFiltersPerRequest {
+		return fmt.Errorf("cannot send filters for heights %d to %d", payload.StartHeight, stop.Height)
+	}
+
+	for height := payload.StartHeight; height <= stop.Height; height++ {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return err
+		}
+
+		if height == stop.Height && !bytes.Equal(block.Hash, stop.Hash) {
+			return fmt.Errorf("block %x is not in the main chain", stop.Hash)
+		}
+
+		filter, err := bc.GetFilter(block.Hash)
+		if err != nil {
+			return err
+		}
+		if filter == nil {
+			payloadData, err := msgpack.Marshal(notFound{nodeAddress, "cfilter", block.Hash})
+			if err != nil {
+				return err
+			}
+
+			err = sendData(payload.AddrFrom, append(commandToBytes("notfound"), payloadData...))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		payloadData, err := msgpack.Marshal(cfilter{nodeAddress, block.Hash, *filter})
+		if err != nil {
+			return err
+		}
+
+		err = sendData(payload.AddrFrom, append(commandToBytes("cfilter"), payloadData...))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func handleGetData(request []byte, bc *blockchain.Blockchain) error {
 	var buff bytes.Buffer
 	var payload getData
@@ -347,12 +737,19 @@ func handleGetData(request []byte, bc *blockchain.Blockchain) error {
 			return err
 		}
 
+		if block.IsPruned() {
+			fmt.Printf("Not sending pruned block %x to %s\n", block.Hash, payload.AddrFrom)
+			return nil
+		}
+
 		return sendBlock(payload.AddrFrom, block)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
+		tx, ok := mempool.get(payload.ID)
+		if !ok {
+			return nil
+		}
 
 		return SendTx(payload.AddrFrom, &tx)
 	}
@@ -375,10 +772,23 @@ func handleTx(request []byte, bc *blockchain.Blockchain) error {
 	if err != nil {
 		return err
 	}
-	mempool[hex.EncodeToString(tx.ID)] = tx
 
-	if nodeAddress == KnownNodes[0] {
-		for _, node := range KnownNodes {
+	valid, err := bc.VerifyTransaction(&tx)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		fmt.Printf("Rejected transaction %x\n", tx.ID)
+		return nil
+	}
+	if !mempool.add(tx) {
+		fmt.Printf("Rejected transaction %x, it spends an output spent in the mempool\n", tx.ID)
+		return nil
+	}
+
+	nodes := knownNodes()
+	if nodeAddress == nodes[0] {
+		for _, node := range nodes {
 			if node != nodeAddress && node != payload.AddFrom {
 				err := sendInv(node, "tx", [][]byte{tx.ID})
 				if err != nil {
@@ -387,19 +797,34 @@ func handleTx(request []byte, bc *blockchain.Blockchain) error {
 			}
 		}
 	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
+		if mempool.count() >= 2 && len(miningAddress) > 0 {
 		MineTransactions:
 			var txs []*transactions.Transaction
+			fees := 0
 
-			for id := range mempool {
-				tx := mempool[id]
+			for _, tx := range mempool.all() {
 				valid, err := bc.VerifyTransaction(&tx)
 				if err != nil {
 					return err
 				}
-				if valid {
-					txs = append(txs, &tx)
+				if !valid {
+					// Its inputs were spent by a block since it was added.
+					mempool.remove(tx.ID)
+					continue
+				}
+
+				fee, err := bc.CalculateFee(&tx)
+				if err != nil {
+					continue
+				}
+
+				total, err := transactions.AddValue(fees, fee, bc.Params.MaxMoney)
+				if err != nil {
+					continue
 				}
+
+				fees = total
+				txs = append(txs, &tx)
 			}
 
 			if len(txs) == 0 {
@@ -407,29 +832,41 @@ func handleTx(request []byte, bc *blockchain.Blockchain) error {
 				return nil
 			}
 
-			cbTx, err := transactions.NewCoinbaseTX(miningAddress, "")
+			bestHeight, err := bc.GetBestHeight()
+			if err != nil {
+				return err
+			}
+
+			reward := bc.Params.BlockSubsidy(bestHeight+1) + fees
+			cbTx, err := transactions.NewCoinbaseTX(miningAddress, "", reward)
 			if err != nil {
 				return err
 			}
 
 			txs = append(txs, cbTx)
 
-			newBlock, err := bc.MineBlock(txs)
+			ctx, done := mining.start()
+			newBlock, err := bc.MineBlock(ctx, txs)
+			done()
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("Mining aborted, the chain tip has changed")
+				return nil
+			}
+			if errors.Is(err, consensus.ErrNotInTurn) {
+				fmt.Println("Waiting for the in-turn authority to seal the next block")
+				return nil
+			}
 			if err != nil {
 				return err
 			}
 
-			UTXOSet := utxo.UTXOSet{Blockchain: bc}
-			UTXOSet.ReIndex()
-
 			fmt.Println("New block is mined!")
 
 			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(mempool, txID)
+				mempool.remove(tx.ID)
 			}
 
-			for _, node := range KnownNodes {
+			for _, node := range knownNodes() {
 				if node != nodeAddress {
 					fmt.Println(node)
 					err := sendInv(node, "block", [][]byte{newBlock.Hash})
@@ -440,7 +877,7 @@ func handleTx(request []byte, bc *blockchain.Blockchain) error {
 				}
 			}
 
-			if len(mempool) > 0 {
+			if mempool.count() > 0 {
 				goto MineTransactions
 			}
 		}
@@ -449,7 +886,7 @@ func handleTx(request []byte, bc *blockchain.Blockchain) error {
 	return nil
 }
 
-func handleVersion(request []byte, bc *blockchain.Blockchain) error {
+func handleVersion(request []byte, remote net.Addr, bc *blockchain.Blockchain) error {
 	var buff bytes.Buffer
 	var payload verzion
 
@@ -461,6 +898,8 @@ func handleVersion(request []byte, bc *blockchain.Blockchain) error {
 		return err
 	}
 
+	timeSource.AddTimeSample(remote, payload.Timestamp)
+
 	myBestHeight, err := bc.GetBestHeight()
 	fmt.Println(myBestHeight)
 	if err != nil {
@@ -470,9 +909,28 @@ func handleVersion(request []byte, bc *blockchain.Blockchain) error {
 	foreignerBestHeight := payload.BestHeight
 
 	if myBestHeight < foreignerBestHeight {
-		err := sendGetBlocks(payload.AddrFrom)
-		if err != nil {
-			return err
+		if payload.Services&serviceNetwork == 0 && foreignerBestHeight-myBestHeight > blockchain.MinPruneDepth {
+			fmt.Printf("Peer %s is pruned and cannot serve the blocks we are missing\n", payload.AddrFrom)
+		} else if belowCheckpoint(bc, myBestHeight) {
+			tip, _, err := bc.Db.GetLastHashAndHeight()
+			if err != nil {
+				return err
+			}
+
+			genesis, err := bc.GetBlockByHeight(0)
+			if err != nil {
+				return err
+			}
+
+			err = sendGetHeaders(payload.AddrFrom, [][]byte{tip, genesis.Hash})
+			if err != nil {
+				return err
+			}
+		} else {
+			err := sendGetBlocks(payload.AddrFrom)
+			if err != nil {
+				return err
+			}
 		}
 	} else if myBestHeight > foreignerBestHeight {
 		err := sendVersion(payload.AddrFrom, bc)
@@ -481,12 +939,18 @@ func handleVersion(request []byte, bc *blockchain.Blockchain) error {
 		}
 	}
 
-	if !nodeIsKnown(payload.AddrFrom) {
-		KnownNodes = append(KnownNodes, payload.AddrFrom)
+	if payload.Services&serviceNetwork != 0 {
+		err := history.requestNext(payload.AddrFrom)
+		if err != nil {
+			return err
+		}
 	}
 
-	for _, node := range KnownNodes {
-		if node != KnownNodes[0] {
+	addKnownNodes(payload.AddrFrom)
+
+	nodes := knownNodes()
+	for _, node := range nodes {
+		if node != nodes[0] {
 			err := sendAddr(node)
 			if err != nil {
 				return err
@@ -502,6 +966,15 @@ func handleConnection(conn net.Conn, bc *blockchain.Blockchain) {
 	if err != nil {
 		log.Panic(err)
 	}
+
+	magicLength := len(chainParams.Magic)
+	if len(request) < magicLength+commandLength || !bytes.Equal(request[:magicLength], chainParams.Magic[:]) {
+		fmt.Printf("Ignoring message from %s: not a %s peer\n", conn.RemoteAddr(), chainParams.Name)
+		conn.Close()
+		return
+	}
+	request = request[magicLength:]
+
 	command := bytesToCommand(request[:commandLength])
 	fmt.Printf("Received %s command\n", command)
 
@@ -516,10 +989,18 @@ func handleConnection(conn net.Conn, bc *blockchain.Blockchain) {
 		handleGetBlocks(request, bc)
 	case "getdata":
 		handleGetData(request, bc)
+	case "getheaders":
+		handleGetHeaders(request, bc)
+	case "headers":
+		handleHeaders(request, bc)
+	case "getproofs":
+		handleGetProofs(request, bc)
+	case "getcfilters":
+		handleGetCFilters(request, bc)
 	case "tx":
 		handleTx(request, bc)
 	case "version":
-		handleVersion(request, bc)
+		handleVersion(request, conn.RemoteAddr(), bc)
 	default:
 		fmt.Println("Unknown command!")
 	}
@@ -527,7 +1008,30 @@ func handleConnection(conn net.Conn, bc *blockchain.Blockchain) {
 	conn.Close()
 }
 
-func StartServer(nodeID, minerAddress string) error {
+func localServices(bc *blockchain.Blockchain) (uint64, error) {
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return 0, err
+	}
+
+	var services uint64 = serviceNetwork
+	if bc.PruneDepth > 0 || pruneHeight > 0 {
+		services = serviceNetworkLimited
+	}
+
+	return services | serviceCompactFilters, nil
+}
+
+func UseNetwork(p *params.ChainParams) {
+	chainParams = p
+
+	knownNodesMu.Lock()
+	KnownNodes = []string{"localhost:" + p.DefaultPort}
+	knownNodesMu.Unlock()
+}
+
+func StartServer(dataDir, nodeID, minerAddress string, miningThreads, pruneDepth int, p *params.ChainParams) error {
+	UseNetwork(p)
 	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
 	miningAddress = minerAddress
 	ln, err := net.Listen(protocol, nodeAddress)
@@ -536,16 +1040,58 @@ func StartServer(nodeID, minerAddress string) error {
 	}
 	defer ln.Close()
 
-	bc, err := blockchain.NewBlockchain()
+	bc, err := blockchain.NewBlockchain(dataDir, chainParams)
+	if err != nil {
+		return err
+	}
+	defer bc.CloseDB()
+	bc.TimeSource = timeSource
+	bc.PruneDepth = pruneDepth
+
+	switch engine := bc.Engine.(type) {
+	case *consensus.ProofOfWork:
+		engine.Workers = miningThreads
+		engine.OnHashRate = func(hashRate float64) {
+			fmt.Printf("Mining at %.0f H/s\n", hashRate)
+		}
+	case *consensus.ProofOfAuthority:
+		if len(miningAddress) > 0 {
+			wallets, err := wallet.NewWallets(dataDir, chainParams.AddressVersion)
+			if err != nil {
+				return err
+			}
+
+			signer, err := wallets.GetWallet(miningAddress)
+			if err != nil {
+				return err
+			}
+
+			bc.Engine = consensus.NewProofOfAuthority(chainParams, &signer.PrivateKey)
+		}
+	}
+	UTXOSet := utxo.UTXOSet{Blockchain: bc}
+	bc.AddIndex(UTXOSet)
+
+	history, err = newHistorySync(UTXOSet)
 	if err != nil {
 		return err
 	}
+	if history != nil {
+		go history.watch()
+	}
 
-	if nodeAddress != KnownNodes[0] {
-		err := sendVersion(KnownNodes[0], bc)
+	if seed := knownNodes()[0]; nodeAddress != seed {
+		err := sendVersion(seed, bc)
 		if err != nil {
 			return err
 		}
+
+		if nodeIsKnown(seed) {
+			err = history.requestNext(seed)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	fmt.Println("Server started")
@@ -559,11 +1105,5 @@ func StartServer(nodeID, minerAddress string) error {
 }
 
 func nodeIsKnown(addr string) bool {
-	for _, node := range KnownNodes {
-		if node == addr {
-			return true
-		}
-	}
-
-	return false
+	return slices.Contains(knownNodes(), addr)
 }
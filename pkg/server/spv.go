@@ -0,0 +1,370 @@
+package server
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/gcs"
+	"amdzy/gochain/pkg/script"
+	"amdzy/gochain/pkg/spv"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const lightClientTimeout = 30 * time.Second
+
+// SyncLightClient brings client up to date with peer: it downloads the
+// headers of peer's main chain, then the transactions touching
+// pubKeyHashes. Replies are received on port. It returns the number of
+// transactions proven.
+//
+// Without useFilters, the peer is sent pubKeyHashes and returns proofs of
+// the transactions. With it, the client matches the peer's compact block
+// filters itself and downloads only the blocks that match, so the peer does
+// not learn its addresses. Filters are not committed to by the headers, so
+// a dishonest peer can hide transactions either way, but cannot forge them.
+func SyncLightClient(client *spv.Client, port, peer string, pubKeyHashes [][]byte, useFilters bool) (int, error) {
+	UseNetwork(client.Params)
+	nodeAddress = fmt.Sprintf("localhost:%s", port)
+
+	ln, err := net.Listen(protocol, nodeAddress)
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+
+	replies := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+
+	go receiveReplies(ln, replies, done)
+
+	err = syncHeaders(client, peer, replies)
+	if err != nil {
+		return 0, err
+	}
+
+	var proven []*blockchain.TxOutProof
+	if useFilters {
+		proven, err = scanFilters(client, peer, replies, pubKeyHashes)
+	} else {
+		proven, err = requestProofs(peer, replies, pubKeyHashes)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return client.SetProofs(proven)
+}
+
+func syncHeaders(client *spv.Client, peer string, replies <-chan []byte) error {
+	for {
+		locator, err := client.Locator()
+		if err != nil {
+			return err
+		}
+
+		payload, err := msgpack.Marshal(getHeaders{nodeAddress, locator})
+		if err != nil {
+			return err
+		}
+
+		err = sendRequest(peer, append(commandToBytes("getheaders"), payload...))
+		if err != nil {
+			return err
+		}
+
+		var reply headers
+		err = awaitReply(replies, "headers", &reply)
+		if err != nil {
+			return err
+		}
+
+		var received []*blockchain.Block
+		for _, headerData := range reply.Headers {
+			header, err := blockchain.DeserializeBlock(headerData)
+			if err != nil {
+				return err
+			}
+			received = append(received, header)
+		}
+
+		err = client.AddHeaders(received)
+		if err != nil {
+			return err
+		}
+
+		if len(received) < maxHeadersPerMessage {
+			return nil
+		}
+	}
+}
+
+func requestProofs(peer string, replies <-chan []byte, pubKeyHashes [][]byte) ([]*blockchain.TxOutProof, error) {
+	payload, err := msgpack.Marshal(getProofs{nodeAddress, pubKeyHashes})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendRequest(peer, append(commandToBytes("getproofs"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	var reply proofs
+	err = awaitReply(replies, "proofs", &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	var received []*blockchain.TxOutProof
+	for _, proofData := range reply.Proofs {
+		proof, err := blockchain.DeserializeTxOutProof(proofData)
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, proof)
+	}
+
+	return received, nil
+}
+
+// scanFilters walks the client's chain from genesis, downloading the blocks
+// whose filters match the scripts paying pubKeyHashes or an output paid to
+// them earlier, and proves the transactions of those blocks that touch
+// pubKeyHashes.
+func scanFilters(client *spv.Client, peer string, replies <-chan []byte, pubKeyHashes [][]byte) ([]*blockchain.TxOutProof, error) {
+	tip, err := client.Tip()
+	if err != nil {
+		return nil, err
+	}
+
+	var watched [][]byte
+	for _, pubKeyHash := range pubKeyHashes {
+		watched = append(watched, script.PayToPubKeyHash(pubKeyHash))
+	}
+	var proven []*blockchain.TxOutProof
+
+	for start := 0; start <= tip.Height; start += maxFiltersPerRequest {
+		stop, err := client.HeaderAt(min(start+maxFiltersPerRequest-1, tip.Height))
+		if err != nil {
+			return nil, err
+		}
+
+		filters, err := requestFilters(peer, replies, start, stop)
+		if err != nil {
+			return nil, err
+		}
+
+		for height := start; height <= stop.Height; height++ {
+			header, err := client.HeaderAt(height)
+			if err != nil {
+				return nil, err
+			}
+
+			// Blocks the peer has no filter for are downloaded in full.
+			filter, ok := filters[hex.EncodeToString(header.Hash)]
+			if ok {
+				matched, err := filter.MatchAny(blockchain.FilterKey(header.Hash), watched)
+				if err != nil {
+					return nil, err
+				}
+				if !matched {
+					continue
+				}
+			}
+
+			block, err := requestBlock(peer, replies, header)
+			if err != nil {
+				return nil, err
+			}
+
+			for i, tx := range block.Transactions {
+				if !spv.Touches(tx, pubKeyHashes) {
+					continue
+				}
+
+				proof, err := blockchain.NewTxOutProof(block, i)
+				if err != nil {
+					return nil, err
+				}
+				proven = append(proven, proof)
+
+				for vout, out := range tx.Vout {
+					for _, pubKeyHash := range pubKeyHashes {
+						if out.IsLockedWithKey(pubKeyHash) {
+							watched = append(watched, blockchain.FilterOutpoint(tx.ID, vout))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return proven, nil
+}
+
+// requestFilters returns the filters of the blocks from start to stop keyed
+// by hex encoded block hash. They may arrive in any order. Blocks the peer
+// has no filter for are left out.
+func requestFilters(peer string, replies <-chan []byte, start int, stop *blockchain.Block) (map[string]gcs.Filter, error) {
+	payload, err := msgpack.Marshal(getCFilters{nodeAddress, start, stop.Hash})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendRequest(peer, append(commandToBytes("getcfilters"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	filters := make(map[string]gcs.Filter)
+	missing := make(map[string]bool)
+	for len(filters)+len(missing) < stop.Height-start+1 {
+		request, err := awaitAnyReply(replies, "cfilter", "notfound")
+		if err != nil {
+			return nil, err
+		}
+
+		if bytesToCommand(request[:commandLength]) == "notfound" {
+			var reply notFound
+			err := msgpack.Unmarshal(request[commandLength:], &reply)
+			if err != nil {
+				return nil, err
+			}
+
+			if reply.Type == "cfilter" {
+				missing[hex.EncodeToString(reply.ID)] = true
+			}
+			continue
+		}
+
+		var reply cfilter
+		err = msgpack.Unmarshal(request[commandLength:], &reply)
+		if err != nil {
+			return nil, err
+		}
+
+		filters[hex.EncodeToString(reply.BlockHash)] = reply.Filter
+	}
+
+	return filters, nil
+}
+
+// requestBlock downloads the block of header and checks that its
+// transactions are the ones header commits to.
+func requestBlock(peer string, replies <-chan []byte, header *blockchain.Block) (*blockchain.Block, error) {
+	payload, err := msgpack.Marshal(getData{nodeAddress, "block", header.Hash})
+	if err != nil {
+		return nil, err
+	}
+
+	err = sendRequest(peer, append(commandToBytes("getdata"), payload...))
+	if err != nil {
+		return nil, err
+	}
+
+	var reply block
+	err = awaitReply(replies, "block", &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	received, err := blockchain.DeserializeBlock(reply.Block)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(received.BlockHeader.Hash(), header.Hash) {
+		return nil, fmt.Errorf("peer sent block %x instead of %x", received.Hash, header.Hash)
+	}
+	received.Height = header.Height
+
+	merkleRoot, err := received.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(merkleRoot, received.MerkleRoot) {
+		return nil, fmt.Errorf("%w: block %x", blockchain.ErrBadMerkleRoot, received.Hash)
+	}
+
+	return received, nil
+}
+
+// sendRequest is sendData for a light client, which only has the one peer
+// and so has to fail when it is not available.
+func sendRequest(peer string, data []byte) error {
+	conn, err := net.Dial(protocol, peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	message := append(chainParams.Magic[:], data...)
+	_, err = io.Copy(conn, bytes.NewReader(message))
+	return err
+}
+
+func receiveReplies(ln net.Listener, replies chan<- []byte, done <-chan struct{}) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			request, err := io.ReadAll(conn)
+			if err != nil {
+				return
+			}
+
+			magicLength := len(chainParams.Magic)
+			if len(request) < magicLength+commandLength || !bytes.Equal(request[:magicLength], chainParams.Magic[:]) {
+				return
+			}
+
+			select {
+			case replies <- request[magicLength:]:
+			case <-done:
+			}
+		}()
+	}
+}
+
+// awaitReply decodes the next message with the given command into payload,
+// ignoring any others.
+func awaitReply(replies <-chan []byte, command string, payload any) error {
+	request, err := awaitAnyReply(replies, command)
+	if err != nil {
+		return err
+	}
+
+	return msgpack.Unmarshal(request[commandLength:], payload)
+}
+
+// awaitAnyReply returns the next message with one of the given commands,
+// ignoring any others.
+func awaitAnyReply(replies <-chan []byte, commands ...string) ([]byte, error) {
+	timeout := time.After(lightClientTimeout)
+
+	for {
+		select {
+		case request := <-replies:
+			if !slices.Contains(commands, bytesToCommand(request[:commandLength])) {
+				continue
+			}
+
+			return request, nil
+		case <-timeout:
+			return nil, fmt.Errorf("no %s reply from peer within %s", strings.Join(commands, " or "), lightClientTimeout)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+// Package spv keeps the state of a light client: the headers of the main
+// chain and proven transactions touching the client's addresses, without
+// the blocks themselves.
+package spv
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/consensus"
+	"amdzy/gochain/pkg/datadir"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"amdzy/gochain/utils"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+)
+
+const dbFile = "spv.db"
+
+const (
+	headersBucket = "headers"
+	heightsBucket = "heights"
+	proofsBucket  = "proofs"
+	tipKey        = "l"
+)
+
+var (
+	ErrNotConnected = errors.New("headers do not connect to the client's chain")
+	ErrWrongGenesis = errors.New("first header is not the network's genesis block")
+)
+
+type Client struct {
+	Db     storage.Store
+	Params *params.ChainParams
+	Engine consensus.Engine
+	lock   *datadir.Lock
+}
+
+// Open opens the light client state in dataDir, creating it if needed.
+func Open(dataDir string, p *params.ChainParams) (*Client, error) {
+	lock, err := datadir.AcquireLock(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := storage.OpenBolt(filepath.Join(dataDir, dbFile), time.Second)
+	if errors.Is(err, storage.ErrTimeout) {
+		lock.Release()
+		return nil, fmt.Errorf("%w: %s", datadir.ErrLocked, dataDir)
+	}
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	client, err := NewClient(store, p)
+	if err != nil {
+		store.Close()
+		lock.Release()
+		return nil, err
+	}
+	client.lock = lock
+
+	return client, nil
+}
+
+// NewClient keeps the light client state in store.
+func NewClient(store storage.Store, p *params.ChainParams) (*Client, error) {
+	err := store.Update(func(tx storage.Tx) error {
+		for _, name := range []string{headersBucket, heightsBucket, proofsBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{Db: store, Params: p, Engine: consensus.New(p)}, nil
+}
+
+func (c *Client) Close() {
+	c.Db.Close()
+	if c.lock != nil {
+		c.lock.Release()
+	}
+}
+
+func (c *Client) GetHeader(hash []byte) (*blockchain.BlockHeader, int, error) {
+	var header *blockchain.Block
+
+	err := c.Db.View(func(tx storage.Tx) error {
+		var err error
+		header, err = getHeader(tx, hash)
+
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &header.BlockHeader, header.Height, nil
+}
+
+// Tip returns the last header of the client's chain, or nil if it has none.
+func (c *Client) Tip() (*blockchain.Block, error) {
+	var tip *blockchain.Block
+
+	err := c.Db.View(func(tx storage.Tx) error {
+		tipHash := tx.Bucket([]byte(headersBucket)).Get([]byte(tipKey))
+		if tipHash == nil {
+			return nil
+		}
+
+		var err error
+		tip, err = getHeader(tx, tipHash)
+
+		return err
+	})
+
+	return tip, err
+}
+
+// Locator lists hashes of the client's chain from the tip back to genesis,
+// densely at first and then exponentially further apart, so that a peer can
+// find the most recent block both chains share.
+func (c *Client) Locator() ([][]byte, error) {
+	tip, err := c.Tip()
+	if err != nil || tip == nil {
+		return nil, err
+	}
+
+	var locator [][]byte
+
+	err = c.Db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(heightsBucket))
+
+		step := 1
+		for height := tip.Height; height > 0; height -= step {
+			locator = append(locator, append([]byte{}, b.Get(heightKey(height))...))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, append([]byte{}, b.Get(heightKey(0))...))
+
+		return nil
+	})
+
+	return locator, err
+}
+
+// AddHeaders connects consecutive headers to the client's chain. The first
+// must follow a header the client has, or be the genesis block of an empty
+// client, as pinned by the GenesisHash of its params. If that is below the client's tip, the headers replace the
+// client's chain above it when they have more work than it, as the peer has
+// reorganized onto another branch.
+func (c *Client) AddHeaders(headers []*blockchain.Block) error {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	tip, err := c.Tip()
+	if err != nil {
+		return err
+	}
+
+	first := headers[0]
+	if tip == nil && first.Height != 0 {
+		return fmt.Errorf("%w: client has no genesis block", ErrNotConnected)
+	}
+	if tip == nil && c.Params.GenesisHash != hex.EncodeToString(first.Hash) {
+		return fmt.Errorf("%w: got %x, want %q", ErrWrongGenesis, first.Hash, c.Params.GenesisHash)
+	}
+	if tip != nil {
+		if first.Height == 0 || first.Height > tip.Height+1 {
+			return fmt.Errorf("%w: block %x at height %d", ErrNotConnected, first.Hash, first.Height)
+		}
+
+		parent, err := c.HeaderAt(first.Height - 1)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(parent.Hash, first.PrevBlockHash) {
+			return fmt.Errorf("%w: block %x at height %d", ErrNotConnected, first.Hash, first.Height)
+		}
+	}
+
+	for i := 1; i < len(headers); i++ {
+		if !bytes.Equal(headers[i].PrevBlockHash, headers[i-1].Hash) {
+			return fmt.Errorf("%w: block %x does not follow %x", ErrNotConnected, headers[i].Hash, headers[i-1].Hash)
+		}
+	}
+
+	last := headers[len(headers)-1]
+
+	return c.Db.Update(func(tx storage.Tx) error {
+		chain := txChain{tx}
+		b := tx.Bucket([]byte(headersBucket))
+		heights := tx.Bucket([]byte(heightsBucket))
+
+		if tip != nil {
+			work, err := branchWork(tx, first.Height, tip.Height)
+			if err != nil {
+				return err
+			}
+
+			if headersWork(headers).Cmp(work) <= 0 {
+				return nil
+			}
+		}
+
+		for _, header := range headers {
+			if !header.IsPruned() {
+				return fmt.Errorf("block %x is not a header", header.Hash)
+			}
+
+			err := blockchain.CheckHeader(chain, c.Engine, header, c.Params)
+			if errors.Is(err, blockchain.ErrUnknownParent) {
+				return fmt.Errorf("%w: %w", ErrNotConnected, err)
+			}
+			if err != nil {
+				return err
+			}
+
+			headerData, err := header.Serialize()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(header.Hash, headerData)
+			if err != nil {
+				return err
+			}
+
+			err = heights.Put(heightKey(header.Height), header.Hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		if tip != nil {
+			for height := last.Height + 1; height <= tip.Height; height++ {
+				err := heights.Delete(heightKey(height))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return b.Put([]byte(tipKey), last.Hash)
+	})
+}
+
+// branchWork sums the work of the client's chain from height from to to.
+func branchWork(tx storage.Tx, from, to int) (*big.Int, error) {
+	heights := tx.Bucket([]byte(heightsBucket))
+	work := new(big.Int)
+
+	for height := from; height <= to; height++ {
+		header, err := getHeader(tx, heights.Get(heightKey(height)))
+		if err != nil {
+			return nil, err
+		}
+
+		work.Add(work, blockchain.BlockWork(header.Bits))
+	}
+
+	return work, nil
+}
+
+func headersWork(headers []*blockchain.Block) *big.Int {
+	work := new(big.Int)
+	for _, header := range headers {
+		work.Add(work, blockchain.BlockWork(header.Bits))
+	}
+
+	return work
+}
+
+// SetProofs replaces the client's proven transactions with proofs. Proofs
+// that are invalid or whose block is not in the client's chain are skipped,
+// and the number that were kept is returned.
+func (c *Client) SetProofs(proofs []*blockchain.TxOutProof) (int, error) {
+	kept := 0
+
+	err := c.Db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket([]byte(proofsBucket))
+		if err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucket([]byte(proofsBucket))
+		if err != nil {
+			return err
+		}
+
+		heights := tx.Bucket([]byte(heightsBucket))
+
+		kept = 0
+		for _, proof := range proofs {
+			if proof.Verify() != nil {
+				continue
+			}
+
+			if !bytes.Equal(heights.Get(heightKey(proof.Height)), proof.Header.Hash()) {
+				continue
+			}
+
+			proofData, err := proof.Serialize()
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(proof.Transaction.ID, proofData)
+			if err != nil {
+				return err
+			}
+			kept++
+		}
+
+		return nil
+	})
+
+	return kept, err
+}
+
+// GetBalance sums the outputs to pubKeyHash of proven transactions that no
+// proven transaction spends. Outputs of coinbases that cannot be spent in
+// the next block yet are returned separately.
+func (c *Client) GetBalance(pubKeyHash []byte) (int, int, error) {
+	tip, err := c.Tip()
+	if err != nil {
+		return 0, 0, err
+	}
+	if tip == nil {
+		return 0, 0, nil
+	}
+
+	var proofs []*blockchain.TxOutProof
+
+	err = c.Db.View(func(tx storage.Tx) error {
+		cur := tx.Bucket([]byte(proofsBucket)).Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			proof, err := blockchain.DeserializeTxOutProof(v)
+			if err != nil {
+				return err
+			}
+
+			proofs = append(proofs, proof)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	spent := make(map[string]bool)
+	for _, proof := range proofs {
+		if proof.Transaction.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range proof.Transaction.Vin {
+			spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)] = true
+		}
+	}
+
+	spendable := 0
+	immature := 0
+	for _, proof := range proofs {
+		mature := !proof.Transaction.IsCoinbase() || tip.Height+1-proof.Height >= c.Params.CoinbaseMaturity
+
+		for i, out := range proof.Transaction.Vout {
+			if !out.IsLockedWithKey(pubKeyHash) || spent[fmt.Sprintf("%x:%d", proof.Transaction.ID, i)] {
+				continue
+			}
+
+			if mature {
+				spendable += out.Value
+			} else {
+				immature += out.Value
+			}
+		}
+	}
+
+	return spendable, immature, nil
+}
+
+// Touches reports whether tx pays to or spends from any of pubKeyHashes.
+func Touches(tx *transactions.Transaction, pubKeyHashes [][]byte) bool {
+	for _, pubKeyHash := range pubKeyHashes {
+		for _, out := range tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) {
+				return true
+			}
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			if vin.UsesKey(pubKeyHash) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HeaderAt returns the header at height in the client's chain.
+func (c *Client) HeaderAt(height int) (*blockchain.Block, error) {
+	var header *blockchain.Block
+
+	err := c.Db.View(func(tx storage.Tx) error {
+		hash := tx.Bucket([]byte(heightsBucket)).Get(heightKey(height))
+		if hash == nil {
+			return blockchain.ErrBlockNotFound
+		}
+
+		var err error
+		header, err = getHeader(tx, hash)
+
+		return err
+	})
+
+	return header, err
+}
+
+// txChain reads headers inside a transaction that may still be adding them.
+type txChain struct {
+	tx storage.Tx
+}
+
+func (c txChain) GetHeader(hash []byte) (*blockchain.BlockHeader, int, error) {
+	header, err := getHeader(c.tx, hash)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &header.BlockHeader, header.Height, nil
+}
+
+func getHeader(tx storage.Tx, hash []byte) (*blockchain.Block, error) {
+	headerData := tx.Bucket([]byte(headersBucket)).Get(hash)
+	if headerData == nil {
+		return nil, blockchain.ErrBlockNotFound
+	}
+
+	return blockchain.DeserializeBlock(headerData)
+}
+
+func heightKey(height int) []byte {
+	return utils.IntToHex(int64(height))
+}
@@ -0,0 +1,155 @@
+package spv_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/spv"
+	"amdzy/gochain/pkg/storage"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newClient starts a client pinned to the genesis block of blocks and adds
+// them.
+func newClient(t *testing.T, blocks ...*blockchain.Block) *spv.Client {
+	p := params.RegTest
+	p.GenesisHash = hex.EncodeToString(blocks[0].Hash)
+
+	client, err := spv.NewClient(storage.NewMemory(), &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.AddHeaders(chaintest.Headers(blocks...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func assertTip(t *testing.T, client *spv.Client, want *blockchain.Block) {
+	t.Helper()
+
+	tip, err := client.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tip.Hash, want.Hash) {
+		t.Fatalf("tip is %x at height %d, want %x at height %d", tip.Hash, tip.Height, want.Hash, want.Height)
+	}
+}
+
+func TestAddHeadersNotLinked(t *testing.T) {
+	c := chaintest.New(t).Extend(4)
+	client := newClient(t, c.Blocks[:4]...)
+
+	// Each header follows one the client has, but not the header before it.
+	fork := c.Mine(c.Blocks[1])
+
+	err := client.AddHeaders(chaintest.Headers(fork, c.Blocks[3], c.Blocks[4]))
+	if !errors.Is(err, spv.ErrNotConnected) {
+		t.Fatalf("got %v, want %v", err, spv.ErrNotConnected)
+	}
+
+	assertTip(t, client, c.Blocks[3])
+}
+
+func TestAddHeadersChainWork(t *testing.T) {
+	c := chaintest.New(t).Extend(4)
+	client := newClient(t, c.Blocks...)
+
+	// A branch with as much work as the client's chain does not replace it.
+	fork := []*blockchain.Block{c.Mine(c.Blocks[2])}
+	fork = append(fork, c.Mine(fork[0]))
+
+	err := client.AddHeaders(chaintest.Headers(fork...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTip(t, client, c.Blocks[4])
+
+	fork = append(fork, c.Mine(fork[1]))
+
+	err = client.AddHeaders(chaintest.Headers(fork...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTip(t, client, fork[2])
+}
+
+func TestAddHeadersTimestamp(t *testing.T) {
+	c := chaintest.New(t).Extend(11)
+	client := newClient(t, c.Blocks...)
+	tip := c.Blocks[11]
+
+	tests := []struct {
+		name      string
+		timestamp int64
+		want      error
+	}{
+		{"median time", c.Blocks[6].Timestamp, blockchain.ErrTimeTooOld},
+		{"too far ahead", time.Now().Add(3 * time.Hour).Unix(), blockchain.ErrTimeTooNew},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.AddHeaders(chaintest.Headers(c.BlockAt(tip, tt.timestamp)))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			assertTip(t, client, tip)
+		})
+	}
+
+	err := client.AddHeaders(chaintest.Headers(c.BlockAt(tip, c.Blocks[6].Timestamp+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddHeadersGenesis(t *testing.T) {
+	c := chaintest.New(t).Extend(2)
+	other := chaintest.New(t)
+
+	tests := []struct {
+		name    string
+		genesis string
+	}{
+		{"other genesis", hex.EncodeToString(other.Blocks[0].Hash)},
+		{"no genesis", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := params.RegTest
+			p.GenesisHash = tt.genesis
+
+			client, err := spv.NewClient(storage.NewMemory(), &p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = client.AddHeaders(chaintest.Headers(c.Blocks...))
+			if !errors.Is(err, spv.ErrWrongGenesis) {
+				t.Fatalf("got %v, want %v", err, spv.ErrWrongGenesis)
+			}
+
+			tip, err := client.Tip()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tip != nil {
+				t.Fatalf("client has tip %x", tip.Hash)
+			}
+		})
+	}
+
+	client := newClient(t, c.Blocks...)
+	assertTip(t, client, c.Blocks[2])
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens the bbolt database at path, creating it if needed, waiting
+// at most timeout for another process to release it.
+func OpenBolt(path string, timeout time.Duration) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx Tx) error) error {
+	return boltError(s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	}))
+}
+
+func (s *boltStore) Update(fn func(tx Tx) error) error {
+	return boltError(s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	}))
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) Bucket(name []byte) Bucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, boltError(err)
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	return boltError(t.tx.DeleteBucket(name))
+}
+
+type boltBucket struct {
+	b *bolt.Bucket
+}
+
+func (b boltBucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b boltBucket) Put(key, value []byte) error {
+	return boltError(b.b.Put(key, value))
+}
+
+func (b boltBucket) Delete(key []byte) error {
+	return boltError(b.b.Delete(key))
+}
+
+func (b boltBucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
+
+// boltError maps bbolt's errors to this package's, so callers need not know
+// which Store they are using.
+func boltError(err error) error {
+	switch err {
+	case bolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bolt.ErrBucketExists:
+		return ErrBucketExists
+	case bolt.ErrTxNotWritable:
+		return ErrTxNotWritable
+	case bolt.ErrDatabaseNotOpen:
+		return ErrClosed
+	}
+
+	return err
+}
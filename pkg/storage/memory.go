@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+	closed  bool
+}
+
+// NewMemory returns an empty Store kept entirely in memory, for tests and
+// simulations. Unlike bbolt, a View must not be opened while the same
+// goroutine holds an Update, or it will deadlock.
+func NewMemory() Store {
+	return &memoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryStore) View(fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	tx := &memoryTx{store: s, writable: true}
+	err := fn(tx)
+	if err != nil {
+		tx.rollback()
+	}
+
+	return err
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	return nil
+}
+
+// memoryTx applies writes in place and records how to revert each of them,
+// so that a failed Update leaves the store as it found it.
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+	undo     []func()
+}
+
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (t *memoryTx) Bucket(name []byte) Bucket {
+	b, ok := t.store.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+
+	return &memoryBucketTx{tx: t, bucket: b}
+}
+
+func (t *memoryTx) CreateBucket(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+
+	key := string(name)
+	if _, ok := t.store.buckets[key]; ok {
+		return nil, ErrBucketExists
+	}
+
+	b := &memoryBucket{values: make(map[string][]byte)}
+	t.store.buckets[key] = b
+	t.undo = append(t.undo, func() { delete(t.store.buckets, key) })
+
+	return &memoryBucketTx{tx: t, bucket: b}, nil
+}
+
+func (t *memoryTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b := t.Bucket(name)
+	if b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memoryTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+
+	key := string(name)
+	b, ok := t.store.buckets[key]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	delete(t.store.buckets, key)
+	t.undo = append(t.undo, func() { t.store.buckets[key] = b })
+
+	return nil
+}
+
+type memoryBucket struct {
+	keys   []string
+	values map[string][]byte
+}
+
+func (b *memoryBucket) set(key string, value []byte) {
+	if _, ok := b.values[key]; !ok {
+		i := sort.SearchStrings(b.keys, key)
+		b.keys = append(b.keys, "")
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = key
+	}
+
+	b.values[key] = value
+}
+
+func (b *memoryBucket) remove(key string) {
+	if _, ok := b.values[key]; !ok {
+		return
+	}
+
+	i := sort.SearchStrings(b.keys, key)
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	delete(b.values, key)
+}
+
+type memoryBucketTx struct {
+	tx     *memoryTx
+	bucket *memoryBucket
+}
+
+func (b *memoryBucketTx) Get(key []byte) []byte {
+	return b.bucket.values[string(key)]
+}
+
+func (b *memoryBucketTx) Put(key, value []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	b.saveUndo(string(key))
+	b.bucket.set(string(key), append([]byte{}, value...))
+
+	return nil
+}
+
+func (b *memoryBucketTx) Delete(key []byte) error {
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+
+	b.saveUndo(string(key))
+	b.bucket.remove(string(key))
+
+	return nil
+}
+
+func (b *memoryBucketTx) saveUndo(key string) {
+	bucket := b.bucket
+	old, ok := bucket.values[key]
+
+	b.tx.undo = append(b.tx.undo, func() {
+		if ok {
+			bucket.set(key, old)
+		} else {
+			bucket.remove(key)
+		}
+	})
+}
+
+func (b *memoryBucketTx) Cursor() Cursor {
+	return &memoryCursor{bucket: b.bucket}
+}
+
+// memoryCursor remembers the key it is at rather than a position, so that it
+// stays correct when the bucket is modified during iteration.
+type memoryCursor struct {
+	bucket *memoryBucket
+	key    string
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	return c.at(0)
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	i := sort.SearchStrings(c.bucket.keys, c.key)
+	if i < len(c.bucket.keys) && c.bucket.keys[i] == c.key {
+		i++
+	}
+
+	return c.at(i)
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.at(sort.SearchStrings(c.bucket.keys, string(seek)))
+}
+
+func (c *memoryCursor) at(i int) ([]byte, []byte) {
+	if i >= len(c.bucket.keys) {
+		return nil, nil
+	}
+
+	c.key = c.bucket.keys[i]
+
+	return []byte(c.key), c.bucket.values[c.key]
+}
@@ -0,0 +1,49 @@
+// Package storage defines the key-value store the chain state is kept in.
+//
+// A Store holds named buckets of byte keys and values. All access happens in
+// transactions: View transactions are read-only, and the writes made by an
+// Update transaction are applied atomically when its function returns nil
+// and discarded when it returns an error.
+package storage
+
+import "errors"
+
+var (
+	ErrBucketNotFound = errors.New("bucket not found")
+	ErrBucketExists   = errors.New("bucket already exists")
+	ErrTxNotWritable  = errors.New("transaction is not writable")
+	ErrTimeout        = errors.New("timed out waiting for the database")
+	ErrClosed         = errors.New("database is closed")
+)
+
+type Store interface {
+	View(fn func(tx Tx) error) error
+	Update(fn func(tx Tx) error) error
+	Close() error
+}
+
+// Tx is a transaction on a Store. Neither it nor the buckets, cursors and
+// values obtained through it may be used after its function returns.
+type Tx interface {
+	// Bucket returns nil if the bucket does not exist.
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+type Bucket interface {
+	// Get returns nil if key is not set. The value must not be modified.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() Cursor
+}
+
+// Cursor walks a bucket in byte order of its keys. Each method returns a nil
+// key once the cursor has moved past the last key.
+type Cursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
@@ -0,0 +1,324 @@
+package storage_test
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errAbort = errors.New("abort")
+
+// forEachStore runs test against every Store implementation, which must all
+// behave the same.
+func forEachStore(t *testing.T, test func(t *testing.T, s storage.Store)) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) storage.Store
+	}{
+		{"bolt", func(t *testing.T) storage.Store {
+			s, err := storage.OpenBolt(filepath.Join(t.TempDir(), "test.db"), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return s
+		}},
+		{"memory", func(t *testing.T) storage.Store {
+			return storage.NewMemory()
+		}},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.open(t)
+			defer s.Close()
+
+			test(t, s)
+		})
+	}
+}
+
+func put(t *testing.T, s storage.Store, bucket string, pairs ...string) {
+	err := s.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < len(pairs); i += 2 {
+			err := b.Put([]byte(pairs[i]), []byte(pairs[i+1]))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func get(t *testing.T, s storage.Store, bucket, key string) []byte {
+	var value []byte
+
+	err := s.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return storage.ErrBucketNotFound
+		}
+		value = bytes.Clone(b.Get([]byte(key)))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func keys(t *testing.T, s storage.Store, bucket string) []string {
+	var keys []string
+
+	err := s.View(func(tx storage.Tx) error {
+		c := tx.Bucket([]byte(bucket)).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keys
+}
+
+func TestPutGetDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		put(t, s, "b", "k1", "v1", "k2", "v2")
+		put(t, s, "b", "k1", "v3")
+
+		if got := get(t, s, "b", "k1"); string(got) != "v3" {
+			t.Fatalf("k1 = %q, want v3", got)
+		}
+		if got := get(t, s, "b", "missing"); got != nil {
+			t.Fatalf("missing = %q, want nil", got)
+		}
+
+		err := s.Update(func(tx storage.Tx) error {
+			return tx.Bucket([]byte("b")).Delete([]byte("k2"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := get(t, s, "b", "k2"); got != nil {
+			t.Fatalf("k2 = %q after delete", got)
+		}
+	})
+}
+
+func TestBuckets(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		err := s.Update(func(tx storage.Tx) error {
+			if tx.Bucket([]byte("b")) != nil {
+				t.Error("bucket exists before it is created")
+			}
+
+			_, err := tx.CreateBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte("b"))
+			if !errors.Is(err, storage.ErrBucketExists) {
+				t.Errorf("CreateBucket twice: got %v, want %v", err, storage.ErrBucketExists)
+			}
+
+			_, err = tx.CreateBucketIfNotExists([]byte("b"))
+			if err != nil {
+				return err
+			}
+
+			err = tx.DeleteBucket([]byte("b"))
+			if err != nil {
+				return err
+			}
+
+			err = tx.DeleteBucket([]byte("b"))
+			if !errors.Is(err, storage.ErrBucketNotFound) {
+				t.Errorf("DeleteBucket twice: got %v, want %v", err, storage.ErrBucketNotFound)
+			}
+
+			if tx.Bucket([]byte("b")) != nil {
+				t.Error("bucket exists after it is deleted")
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestViewIsReadOnly(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		put(t, s, "b", "k", "v")
+
+		err := s.View(func(tx storage.Tx) error {
+			err := tx.Bucket([]byte("b")).Put([]byte("k"), []byte("w"))
+			if !errors.Is(err, storage.ErrTxNotWritable) {
+				t.Errorf("Put: got %v, want %v", err, storage.ErrTxNotWritable)
+			}
+
+			err = tx.Bucket([]byte("b")).Delete([]byte("k"))
+			if !errors.Is(err, storage.ErrTxNotWritable) {
+				t.Errorf("Delete: got %v, want %v", err, storage.ErrTxNotWritable)
+			}
+
+			_, err = tx.CreateBucket([]byte("c"))
+			if !errors.Is(err, storage.ErrTxNotWritable) {
+				t.Errorf("CreateBucket: got %v, want %v", err, storage.ErrTxNotWritable)
+			}
+
+			err = tx.DeleteBucket([]byte("b"))
+			if !errors.Is(err, storage.ErrTxNotWritable) {
+				t.Errorf("DeleteBucket: got %v, want %v", err, storage.ErrTxNotWritable)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := get(t, s, "b", "k"); string(got) != "v" {
+			t.Fatalf("k = %q, want v", got)
+		}
+	})
+}
+
+func TestFailedUpdateRollsBack(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		put(t, s, "b", "k1", "v1", "k2", "v2")
+		put(t, s, "gone", "k", "v")
+
+		err := s.Update(func(tx storage.Tx) error {
+			b := tx.Bucket([]byte("b"))
+
+			err := b.Put([]byte("k1"), []byte("changed"))
+			if err != nil {
+				return err
+			}
+
+			err = b.Put([]byte("k3"), []byte("new"))
+			if err != nil {
+				return err
+			}
+
+			err = b.Delete([]byte("k2"))
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.CreateBucket([]byte("created"))
+			if err != nil {
+				return err
+			}
+
+			err = tx.DeleteBucket([]byte("gone"))
+			if err != nil {
+				return err
+			}
+
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("got %v, want %v", err, errAbort)
+		}
+
+		if got := keys(t, s, "b"); len(got) != 2 || got[0] != "k1" || got[1] != "k2" {
+			t.Fatalf("keys = %q, want [k1 k2]", got)
+		}
+		if got := get(t, s, "b", "k1"); string(got) != "v1" {
+			t.Fatalf("k1 = %q, want v1", got)
+		}
+		if got := get(t, s, "gone", "k"); string(got) != "v" {
+			t.Fatalf("deleted bucket was not restored, k = %q", got)
+		}
+
+		err = s.View(func(tx storage.Tx) error {
+			if tx.Bucket([]byte("created")) != nil {
+				t.Error("created bucket was not removed")
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCursor(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		put(t, s, "b", "c", "3", "a", "1", "e", "5", "b", "2")
+
+		if got := keys(t, s, "b"); len(got) != 4 || got[0] != "a" || got[1] != "b" || got[2] != "c" || got[3] != "e" {
+			t.Fatalf("keys = %q, want [a b c e]", got)
+		}
+
+		err := s.View(func(tx storage.Tx) error {
+			c := tx.Bucket([]byte("b")).Cursor()
+
+			if k, v := c.Seek([]byte("c")); string(k) != "c" || string(v) != "3" {
+				t.Errorf("Seek(c) = %q, %q", k, v)
+			}
+			if k, _ := c.Seek([]byte("d")); string(k) != "e" {
+				t.Errorf("Seek(d) = %q, want e", k)
+			}
+			if k, _ := c.Next(); k != nil {
+				t.Errorf("Next past the end = %q, want nil", k)
+			}
+			if k, _ := c.Seek([]byte("f")); k != nil {
+				t.Errorf("Seek(f) = %q, want nil", k)
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		put(t, s, "empty")
+		if got := keys(t, s, "empty"); got != nil {
+			t.Fatalf("keys of an empty bucket = %q", got)
+		}
+	})
+}
+
+func TestClosed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storage.Store) {
+		err := s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.View(func(tx storage.Tx) error { return nil })
+		if !errors.Is(err, storage.ErrClosed) {
+			t.Fatalf("View: got %v, want %v", err, storage.ErrClosed)
+		}
+
+		err = s.Update(func(tx storage.Tx) error { return nil })
+		if !errors.Is(err, storage.ErrClosed) {
+			t.Fatalf("Update: got %v, want %v", err, storage.ErrClosed)
+		}
+	})
+}
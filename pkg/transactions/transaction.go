@@ -1,11 +1,13 @@
 package transactions
 
 import (
+	"amdzy/gochain/pkg/script"
+	"amdzy/gochain/pkg/wallet"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 	"strings"
@@ -13,7 +15,7 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 )
 
-var subsidy = 10
+const signatureLen = 64
 
 type Transaction struct {
 	ID   []byte
@@ -61,11 +63,11 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	var outputs []TXOutput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil})
 	}
 
 	for _, vout := range tx.Vout {
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+		outputs = append(outputs, TXOutput{vout.Value, vout.ScriptPubKey})
 	}
 
 	txCopy := Transaction{tx.ID, inputs, outputs}
@@ -73,88 +75,180 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 	return txCopy
 }
 
+// SignatureHash is the hash signatures of input inID commit to: the
+// transaction without signature scripts, with the script of the output the
+// input spends in place of its own.
+func (tx *Transaction) SignatureHash(inID int, scriptPubKey []byte) ([]byte, error) {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inID].ScriptSig = scriptPubKey
+
+	return txCopy.Hash()
+}
+
+// Sign unlocks every input, each of which must spend a PayToPubKeyHash
+// output to the key of privKey.
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
 		return nil
 	}
 
+	pubKey := wallet.EncodePublicKey(&privKey.PublicKey)
+	pubKeyHash, err := wallet.HashPubKey(pubKey)
+	if err != nil {
+		return err
+	}
+
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil {
 			return fmt.Errorf("previous transaction is not correct")
 		}
-	}
 
-	txCopy := tx.TrimmedCopy()
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return fmt.Errorf("input references a missing output")
+		}
+
+		if !prevTx.Vout[vin.Vout].IsLockedWithKey(pubKeyHash) {
+			return fmt.Errorf("output %x:%d is not locked with the key", vin.Txid, vin.Vout)
+		}
+	}
 
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		txCopyHash, err := txCopy.Hash()
+		hash, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].ScriptPubKey)
 		if err != nil {
 			return err
 		}
-		txCopy.ID = txCopyHash
-		txCopy.Vin[inID].PubKey = nil
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
+		r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
 		if err != nil {
 			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
-		tx.Vin[inID].Signature = signature
+		signature := make([]byte, signatureLen)
+		r.FillBytes(signature[:signatureLen/2])
+		s.FillBytes(signature[signatureLen/2:])
+
+		tx.Vin[inID].ScriptSig = script.PushData(signature, pubKey)
 	}
 
-	return nil
+	return tx.SetID()
 }
 
+// Verify runs the script of every input against the script of the output
+// it spends, and returns the error of the first script that fails.
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 	if tx.IsCoinbase() {
 		return true, nil
 	}
 
 	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil {
 			return false, fmt.Errorf("previous transaction is not correct")
 		}
-	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false, fmt.Errorf("input references a missing output")
+		}
+	}
 
 	for inID, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
-		txCopyHash, err := txCopy.Hash()
+		scriptPubKey := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.Vout].ScriptPubKey
+
+		hash, err := tx.SignatureHash(inID, scriptPubKey)
 		if err != nil {
 			return false, err
 		}
-		txCopy.ID = txCopyHash
-		txCopy.Vin[inID].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen / 2)])
-		y.SetBytes(vin.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) {
-			return false, nil
+
+		err = script.Execute(vin.ScriptSig, scriptPubKey, sigChecker{hash})
+		if err != nil {
+			return false, fmt.Errorf("input %d: %w", inID, err)
 		}
 	}
 
 	return true, nil
 }
 
+// sigChecker checks signatures over hash, the signature hash of the input
+// being verified.
+type sigChecker struct {
+	hash []byte
+}
+
+func (c sigChecker) CheckSig(signature, pubKey []byte) bool {
+	if len(signature) != signatureLen {
+		return false
+	}
+
+	rawPubKey, err := wallet.DecodePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+
+	r := big.Int{}
+	s := big.Int{}
+	r.SetBytes(signature[:signatureLen/2])
+	s.SetBytes(signature[signatureLen/2:])
+
+	return ecdsa.Verify(rawPubKey, c.hash, &r, &s)
+}
+
+// ErrMoneyRange is returned for a value, or a sum of values, that is negative
+// or above the maximum money supply.
+var ErrMoneyRange = errors.New("value is out of the money range")
+
+// AddValue returns sum plus value, where sum is already in range, unless the
+// result would be out of the range from 0 to maxMoney.
+func AddValue(sum, value, maxMoney int) (int, error) {
+	if value < 0 || value > maxMoney-sum {
+		return 0, fmt.Errorf("%w: %d + %d > %d", ErrMoneyRange, sum, value, maxMoney)
+	}
+
+	return sum + value, nil
+}
+
+// Fee returns the inputs of tx less its outputs. Each output and every sum
+// of inputs or outputs must not exceed maxMoney.
+func (tx *Transaction) Fee(prevTXs map[string]Transaction, maxMoney int) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputs := 0
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil {
+			return 0, fmt.Errorf("previous transaction is not correct")
+		}
+
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("input references a missing output")
+		}
+
+		var err error
+		inputs, err = AddValue(inputs, prevTx.Vout[vin.Vout].Value, maxMoney)
+		if err != nil {
+			return 0, fmt.Errorf("input sum: %w", err)
+		}
+	}
+
+	outputs := 0
+	for _, vout := range tx.Vout {
+		var err error
+		outputs, err = AddValue(outputs, vout.Value, maxMoney)
+		if err != nil {
+			return 0, fmt.Errorf("output sum: %w", err)
+		}
+	}
+
+	if outputs > inputs {
+		return 0, fmt.Errorf("transaction spends more than its inputs")
+	}
+
+	return inputs - outputs, nil
+}
+
 func (tx Transaction) String() string {
 	var lines []string
 
@@ -165,20 +259,23 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %x", input.ScriptSig))
+		} else {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", script.Disassemble(input.ScriptSig)))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", script.Disassemble(output.ScriptPubKey)))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
-func NewCoinbaseTX(to, data string) (*Transaction, error) {
+func NewCoinbaseTX(to, data string, value int) (*Transaction, error) {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -189,8 +286,8 @@ func NewCoinbaseTX(to, data string) (*Transaction, error) {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txin := TXInput{[]byte{}, -1, []byte(data)}
+	txout := NewTXOutput(value, to)
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
 	err := tx.SetID()
 
@@ -1,6 +1,7 @@
 package transactions
 
 import (
+	"amdzy/gochain/pkg/script"
 	"amdzy/gochain/pkg/wallet"
 	"bytes"
 )
@@ -8,12 +9,18 @@ import (
 type TXInput struct {
 	Txid      []byte
 	Vout      int
-	Signature []byte
-	PubKey    []byte
+	ScriptSig []byte
 }
 
+// UsesKey reports whether the input spends a PayToPubKeyHash output with the
+// key hashing to pubKeyHash.
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash, err := wallet.HashPubKey(in.PubKey)
+	pushes, err := script.PushedData(in.ScriptSig)
+	if err != nil || len(pushes) != 2 {
+		return false
+	}
+
+	lockingHash, err := wallet.HashPubKey(pushes[1])
 	if err != nil {
 		return false
 	}
@@ -1,6 +1,7 @@
 package transactions
 
 import (
+	"amdzy/gochain/pkg/script"
 	"amdzy/gochain/utils"
 	"bytes"
 
@@ -8,18 +9,20 @@ import (
 )
 
 type TXOutput struct {
-	Value      int
-	PubKeyHash []byte
+	Value        int
+	ScriptPubKey []byte
 }
 
 func (out *TXOutput) Lock(address []byte) {
 	pubKeyHash := utils.Base58Decode(address)
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+	out.ScriptPubKey = script.PayToPubKeyHash(pubKeyHash)
 }
 
+// IsLockedWithKey reports whether out is a PayToPubKeyHash output to
+// pubKeyHash.
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return bytes.Equal(out.ScriptPubKey, script.PayToPubKeyHash(pubKeyHash))
 }
 
 func NewTXOutput(value int, address string) *TXOutput {
@@ -30,7 +33,9 @@ func NewTXOutput(value int, address string) *TXOutput {
 }
 
 type TXOutputs struct {
-	Outputs []TXOutput
+	Outputs  map[int]TXOutput
+	Height   int
+	Coinbase bool
 }
 
 func (outs TXOutputs) Serialize() ([]byte, error) {
@@ -47,3 +52,23 @@ func DeserializeOutputs(data []byte) (TXOutputs, error) {
 
 	return outputs, nil
 }
+
+// Transaction rebuilds as much of transaction id as outs describe: the
+// unspent outputs at their original positions and, for coinbases, the
+// coinbase input. Spent positions are left as zero outputs.
+func (outs TXOutputs) Transaction(id []byte) *Transaction {
+	tx := &Transaction{ID: id}
+
+	for idx, out := range outs.Outputs {
+		for len(tx.Vout) <= idx {
+			tx.Vout = append(tx.Vout, TXOutput{})
+		}
+		tx.Vout[idx] = out
+	}
+
+	if outs.Coinbase {
+		tx.Vin = []TXInput{{Vout: -1}}
+	}
+
+	return tx
+}
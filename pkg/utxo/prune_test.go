@@ -0,0 +1,69 @@
+package utxo
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	c, set := newTestSet(t)
+	c.BC.PruneDepth = 3
+	c.Extend(8)
+
+	pruneHeight, err := c.BC.PruneHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruneHeight != 5 {
+		t.Fatalf("prune height %d, want 5", pruneHeight)
+	}
+
+	undo := undoRecords(t, set, c.Blocks...)
+	for height, want := range c.Blocks {
+		block, err := c.BC.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pruned := height > 0 && height <= pruneHeight
+		if block.IsPruned() != pruned {
+			t.Errorf("block at height %d: pruned %t, want %t", height, block.IsPruned(), pruned)
+		}
+		if len(block.Transactions) == 0 && !pruned {
+			t.Errorf("block at height %d lost its transactions", height)
+		}
+		if block.Height != want.Height {
+			t.Errorf("block at height %d has height %d", height, block.Height)
+		}
+
+		// Undo data is kept for the blocks that can still be disconnected.
+		if (undo[height] == nil) != pruned {
+			t.Errorf("block at height %d: has undo data %t, want %t", height, undo[height] != nil, !pruned)
+		}
+	}
+
+	// The unspent outputs of pruned blocks can still be spent.
+	c.Mine(c.Tip(), c.Spend(c.Blocks[1].Transactions[0], 1))
+}
+
+func TestPrunedBlocksCannotBeDisconnected(t *testing.T) {
+	c, _ := newTestSet(t)
+	c.BC.PruneDepth = 3
+	c.Extend(8)
+
+	err := c.BC.AddBlock(c.Block(c.Blocks[2]))
+	if !errors.Is(err, blockchain.ErrPruned) {
+		t.Fatalf("fork below the prune height: got %v, want %v", err, blockchain.ErrPruned)
+	}
+
+	// Forks within the window are still possible.
+	fork := c.Mine(c.Blocks[6])
+	fork = c.Mine(fork)
+	fork = c.Mine(fork)
+
+	if tip := c.Tip(); tip.Height != 9 || !bytes.Equal(tip.Hash, fork.Hash) {
+		t.Fatalf("tip is %x at height %d, want the fork %x", tip.Hash, tip.Height, fork.Hash)
+	}
+}
@@ -0,0 +1,101 @@
+package utxo
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/storage"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// newTestSet starts a chain that keeps its UTXO set.
+func newTestSet(t *testing.T) (*chaintest.Chain, UTXOSet) {
+	c := chaintest.New(t)
+
+	set := UTXOSet{Blockchain: c.BC}
+	err := set.ReIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BC.AddIndex(set)
+
+	return c, set
+}
+
+func setHash(t *testing.T, set UTXOSet) []byte {
+	hash, _, err := set.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+// undoRecords returns the undo data of blocks, with nil for blocks that
+// have none.
+func undoRecords(t *testing.T, set UTXOSet, blocks ...*blockchain.Block) []*blockUndo {
+	var records []*blockUndo
+
+	err := set.Blockchain.Db.View(func(tx storage.Tx) error {
+		for _, block := range blocks {
+			if tx.Bucket([]byte(undoBucket)).Get(block.Hash) == nil {
+				records = append(records, nil)
+				continue
+			}
+
+			undo, err := getUndo(tx, block.Hash)
+			if err != nil {
+				return err
+			}
+			records = append(records, &undo)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+// After a reorganization the set and undo data match those rebuilt from
+// scratch for the new main chain.
+func TestReorganizeUTXOSet(t *testing.T) {
+	c, set := newTestSet(t)
+
+	c.Extend(c.BC.Params.CoinbaseMaturity)
+	fork := c.Tip()
+	spent := c.Blocks[0].Transactions[0]
+
+	main := []*blockchain.Block{c.Mine(fork, c.Spend(spent, 1))}
+	main = append(main, c.Mine(main[0]))
+
+	side := []*blockchain.Block{c.Mine(fork, c.Spend(spent, 2))}
+	side = append(side, c.Mine(side[0]))
+	side = append(side, c.Mine(side[1]))
+
+	if tip := c.Tip(); !bytes.Equal(tip.Hash, side[2].Hash) {
+		t.Fatalf("tip is %x, want the side branch %x", tip.Hash, side[2].Hash)
+	}
+
+	if got := undoRecords(t, set, main...); got[0] != nil || got[1] != nil {
+		t.Fatal("undo data was kept for disconnected blocks")
+	}
+
+	hash := setHash(t, set)
+	undo := undoRecords(t, set, append(c.Blocks, side...)...)
+
+	err := set.ReIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(setHash(t, set), hash) {
+		t.Fatal("utxo set after the reorganization differs from a rebuilt one")
+	}
+	if got := undoRecords(t, set, append(c.Blocks, side...)...); !reflect.DeepEqual(got, undo) {
+		t.Fatal("undo data after the reorganization differs from rebuilt data")
+	}
+}
@@ -0,0 +1,520 @@
+package utxo
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"amdzy/gochain/utils"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const snapshotBucket = "snapshot"
+const snapshotStateKey = "state"
+
+// backgroundBucket holds the UTXO set rebuilt from the blocks below a loaded
+// snapshot while they are being validated.
+const backgroundBucket = "utxo-background"
+
+// maxRecordSize bounds the length prefix of snapshot records.
+const maxRecordSize = 32 << 20
+
+var (
+	ErrSnapshotNotTrusted = errors.New("utxo snapshot does not match a trusted snapshot")
+	ErrSnapshotInvalid    = errors.New("history does not lead to the loaded utxo snapshot")
+)
+
+// SnapshotMetadata describes a UTXO set snapshot taken at the block
+// BlockHash at Height. Transactions is the number of entries in the set.
+type SnapshotMetadata struct {
+	BlockHash    []byte
+	Height       int
+	UTXOHash     []byte
+	Transactions int
+}
+
+// SnapshotStatus tracks the background validation of a loaded snapshot.
+// NextHeight is the next historical block to validate. Failure is set once
+// the history turned out not to lead to the snapshot, after which the set
+// must not be used.
+type SnapshotStatus struct {
+	SnapshotMetadata
+	NextHeight int
+	Validated  bool
+	Failure    string
+}
+
+type snapshotEntry struct {
+	TxID    []byte
+	Outputs transactions.TXOutputs
+}
+
+// Hash returns a hash that commits to every unspent output together with
+// its height and coinbase flag. Entries are hashed in transaction id and
+// output index order, so the result only depends on the contents of the set.
+func (u UTXOSet) Hash() ([]byte, int, error) {
+	var setHash []byte
+	var count int
+
+	err := u.Blockchain.Db.View(func(tx storage.Tx) error {
+		var err error
+		setHash, count, err = hashBucket(tx.Bucket([]byte(utxoBucket)))
+
+		return err
+	})
+
+	return setHash, count, err
+}
+
+func hashBucket(b storage.Bucket) ([]byte, int, error) {
+	hasher := sha256.New()
+	count := 0
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		outs, err := transactions.DeserializeOutputs(v)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		hashEntry(hasher, k, outs)
+		count++
+	}
+
+	return hasher.Sum(nil), count, nil
+}
+
+func hashEntry(hasher hash.Hash, txID []byte, outs transactions.TXOutputs) {
+	coinbase := byte(0)
+	if outs.Coinbase {
+		coinbase = 1
+	}
+
+	hasher.Write(utils.IntToHex(int64(len(txID))))
+	hasher.Write(txID)
+	hasher.Write(utils.IntToHex(int64(outs.Height)))
+	hasher.Write([]byte{coinbase})
+	hasher.Write(utils.IntToHex(int64(len(outs.Outputs))))
+
+	indexes := make([]int, 0, len(outs.Outputs))
+	for idx := range outs.Outputs {
+		indexes = append(indexes, idx)
+	}
+	slices.Sort(indexes)
+
+	for _, idx := range indexes {
+		out := outs.Outputs[idx]
+		hasher.Write(utils.IntToHex(int64(idx)))
+		hasher.Write(utils.IntToHex(int64(out.Value)))
+		hasher.Write(utils.IntToHex(int64(len(out.ScriptPubKey))))
+		hasher.Write(out.ScriptPubKey)
+	}
+}
+
+// DumpSnapshot writes the UTXO set at the current tip to w: the network
+// magic, the snapshot metadata, the headers of every block up to the tip and
+// finally the entries of the set, each as a length prefixed record.
+func (u UTXOSet) DumpSnapshot(w io.Writer) (*SnapshotMetadata, error) {
+	bc := u.Blockchain
+
+	tipHash, tipHeight, err := bc.Db.GetLastHashAndHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]*blockchain.Block, tipHeight+1)
+	for height := range headers {
+		headers[height], err = bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		headers[height].Transactions = nil
+	}
+
+	bw := bufio.NewWriter(w)
+
+	_, err = bw.Write(bc.Params.Magic[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata *SnapshotMetadata
+
+	err = bc.Db.View(func(tx storage.Tx) error {
+		err := checkSnapshot(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(utxoBucket))
+
+		setHash, count, err := hashBucket(b)
+		if err != nil {
+			return err
+		}
+
+		metadata = &SnapshotMetadata{tipHash, tipHeight, setHash, count}
+		err = writeRecord(bw, metadata)
+		if err != nil {
+			return err
+		}
+
+		for _, header := range headers {
+			err := writeRecord(bw, header)
+			if err != nil {
+				return err
+			}
+		}
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := transactions.DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			err = writeRecord(bw, snapshotEntry{k, outs})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, bw.Flush()
+}
+
+// LoadSnapshot starts a new chain in dataDir from a snapshot written by
+// DumpSnapshot. The snapshot must match one of p.AssumeUTXO. The blocks
+// below it are validated later with ValidateSnapshotBlock.
+func LoadSnapshot(dataDir string, r io.Reader, p *params.ChainParams) (*blockchain.Blockchain, *SnapshotMetadata, error) {
+	br := bufio.NewReader(r)
+
+	var magic [4]byte
+	_, err := io.ReadFull(br, magic[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read snapshot header: %w", err)
+	}
+
+	if !bytes.Equal(magic[:], p.Magic[:]) {
+		return nil, nil, fmt.Errorf("snapshot belongs to another network, want %s", p.Name)
+	}
+
+	var metadata SnapshotMetadata
+	err = readRecord(br, &metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trusted, ok := p.AssumeUTXOAt(metadata.Height)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: none at height %d", ErrSnapshotNotTrusted, metadata.Height)
+	}
+
+	if trusted.BlockHash != hex.EncodeToString(metadata.BlockHash) || trusted.UTXOHash != hex.EncodeToString(metadata.UTXOHash) {
+		return nil, nil, fmt.Errorf("%w: block %x, utxo hash %x", ErrSnapshotNotTrusted, metadata.BlockHash, metadata.UTXOHash)
+	}
+
+	headers := make([]*blockchain.Block, metadata.Height+1)
+	for i := range headers {
+		headers[i] = &blockchain.Block{}
+
+		err := readRecord(br, headers[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if !bytes.Equal(headers[metadata.Height].Hash, metadata.BlockHash) {
+		return nil, nil, fmt.Errorf("snapshot headers end at %x, want %x", headers[metadata.Height].Hash, metadata.BlockHash)
+	}
+
+	if metadata.Transactions < 0 {
+		return nil, nil, fmt.Errorf("snapshot has %d entries", metadata.Transactions)
+	}
+
+	// The count is not covered by the trusted hash, so entries are only
+	// allocated as they are read.
+	var entries []snapshotEntry
+	hasher := sha256.New()
+
+	for i := 0; i < metadata.Transactions; i++ {
+		var entry snapshotEntry
+		err := readRecord(br, &entry)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i > 0 && bytes.Compare(entries[i-1].TxID, entry.TxID) >= 0 {
+			return nil, nil, errors.New("snapshot entries are not sorted")
+		}
+
+		hashEntry(hasher, entry.TxID, entry.Outputs)
+		entries = append(entries, entry)
+	}
+
+	setHash := hasher.Sum(nil)
+	if !bytes.Equal(setHash, metadata.UTXOHash) {
+		return nil, nil, fmt.Errorf("%w: snapshot contents hash to %x", ErrSnapshotNotTrusted, setHash)
+	}
+
+	bc, err := blockchain.CreateBlockChainFromHeaders(dataDir, headers, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = bc.Db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucket([]byte(utxoBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			err := putOutputs(b, entry.TxID, entry.Outputs)
+			if err != nil {
+				return err
+			}
+		}
+
+		return putSnapshotStatus(tx, &SnapshotStatus{SnapshotMetadata: metadata})
+	})
+	if err != nil {
+		bc.CloseDB()
+		return nil, nil, err
+	}
+
+	return bc, &metadata, nil
+}
+
+// SnapshotStatus returns nil if the chain was not started from a snapshot.
+func (u UTXOSet) SnapshotStatus() (*SnapshotStatus, error) {
+	var status *SnapshotStatus
+
+	err := u.Blockchain.Db.View(func(tx storage.Tx) error {
+		var err error
+		status, err = getSnapshotStatus(tx)
+
+		return err
+	})
+
+	return status, err
+}
+
+// ValidateSnapshotBlock validates the historical block at the status'
+// NextHeight and connects it to the background UTXO set. Once the snapshot
+// height is reached the background set has to hash to the snapshot's hash,
+// otherwise ErrSnapshotInvalid is returned.
+func (u UTXOSet) ValidateSnapshotBlock(block *blockchain.Block) (*SnapshotStatus, error) {
+	bc := u.Blockchain
+
+	status, err := u.SnapshotStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	if status == nil || status.Validated {
+		return status, nil
+	}
+
+	if status.Failure != "" {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotInvalid, status.Failure)
+	}
+
+	header, err := bc.GetBlockByHeight(status.NextHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(block.Hash, header.Hash) || !bytes.Equal(block.BlockHeader.Hash(), header.Hash) {
+		return nil, fmt.Errorf("block %x is not the main chain block at height %d", block.Hash, status.NextHeight)
+	}
+	block.Height = header.Height
+
+	if block.IsPruned() {
+		return nil, fmt.Errorf("%w: block %x", blockchain.ErrPruned, block.Hash)
+	}
+
+	merkleRoot, err := block.HashTransactions()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(merkleRoot, block.MerkleRoot) {
+		return nil, blockchain.ErrBadMerkleRoot
+	}
+
+	assumeValid, err := bc.AssumeValid(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The block matches the header chain, so a block that does not validate
+	// means the history, and with it the snapshot, is invalid.
+	var failure string
+
+	err = bc.Db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(backgroundBucket))
+		if err != nil {
+			return err
+		}
+
+		err = bc.ValidateBlockTransactions(block, func(txID []byte) (*transactions.TXOutputs, error) {
+			return fetchOutputs(b, txID)
+		}, assumeValid)
+		if err != nil {
+			failure = fmt.Sprintf("block %x at height %d: %v", block.Hash, block.Height, err)
+			return fmt.Errorf("%w: %s", ErrSnapshotInvalid, failure)
+		}
+
+		_, err = connectOutputs(b, block)
+		if err != nil {
+			return err
+		}
+
+		status.NextHeight++
+
+		if status.NextHeight > status.Height {
+			setHash, _, err := hashBucket(b)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(setHash, status.UTXOHash) {
+				failure = fmt.Sprintf("history leads to utxo hash %x, want %x", setHash, status.UTXOHash)
+				return fmt.Errorf("%w: %s", ErrSnapshotInvalid, failure)
+			}
+
+			status.Validated = true
+
+			err = tx.DeleteBucket([]byte(backgroundBucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		return putSnapshotStatus(tx, status)
+	})
+	if failure != "" {
+		return nil, errors.Join(err, u.failSnapshot(failure))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// failSnapshot records why the snapshot is invalid, so that it is not used
+// again.
+func (u UTXOSet) failSnapshot(failure string) error {
+	return u.Blockchain.Db.Update(func(tx storage.Tx) error {
+		status, err := getSnapshotStatus(tx)
+		if err != nil {
+			return err
+		}
+
+		status.Failure = failure
+
+		err = tx.DeleteBucket([]byte(backgroundBucket))
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+
+		return putSnapshotStatus(tx, status)
+	})
+}
+
+// checkSnapshot fails if the set was loaded from a snapshot that background
+// validation proved invalid.
+func checkSnapshot(tx storage.Tx) error {
+	status, err := getSnapshotStatus(tx)
+	if err != nil {
+		return err
+	}
+
+	if status != nil && status.Failure != "" {
+		return fmt.Errorf("%w: %s", ErrSnapshotInvalid, status.Failure)
+	}
+
+	return nil
+}
+
+func getSnapshotStatus(tx storage.Tx) (*SnapshotStatus, error) {
+	b := tx.Bucket([]byte(snapshotBucket))
+	if b == nil {
+		return nil, nil
+	}
+
+	var status SnapshotStatus
+	err := msgpack.Unmarshal(b.Get([]byte(snapshotStateKey)), &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func putSnapshotStatus(tx storage.Tx, status *SnapshotStatus) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(snapshotBucket))
+	if err != nil {
+		return err
+	}
+
+	statusData, err := msgpack.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(snapshotStateKey), statusData)
+}
+
+func writeRecord(w io.Writer, v any) error {
+	data, err := msgpack.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(w, binary.BigEndian, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+func readRecord(r io.Reader, v any) error {
+	var length uint32
+
+	err := binary.Read(r, binary.BigEndian, &length)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot record: %w", err)
+	}
+
+	if length > maxRecordSize {
+		return fmt.Errorf("snapshot record of %d bytes exceeds the maximum of %d", length, maxRecordSize)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot record: %w", err)
+	}
+
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,122 @@
+package utxo_test
+
+import (
+	"amdzy/gochain/pkg/params"
+	"amdzy/gochain/pkg/utxo"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// dumpSnapshot dumps the set of c and returns the params trusting it.
+func dumpSnapshot(t *testing.T, c *testChain) ([]byte, *utxo.SnapshotMetadata, *params.ChainParams) {
+	var buf bytes.Buffer
+	metadata, err := c.set.DumpSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := params.RegTest
+	p.AssumeUTXO = []params.AssumeUTXO{{
+		Height:    metadata.Height,
+		BlockHash: hex.EncodeToString(metadata.BlockHash),
+		UTXOHash:  hex.EncodeToString(metadata.UTXOHash),
+	}}
+
+	return buf.Bytes(), metadata, &p
+}
+
+func TestLoadSnapshotUntrustedCount(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 3; i++ {
+		c.Mine(c.Tip())
+	}
+
+	snapshot, metadata, p := dumpSnapshot(t, c)
+
+	// Replace the metadata record, which follows the network magic.
+	length := binary.BigEndian.Uint32(snapshot[4:8])
+	rest := snapshot[8+length:]
+
+	metadata.Transactions = 1 << 40
+	data, err := msgpack.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, snapshot[:4]...)
+	tampered = binary.BigEndian.AppendUint32(tampered, uint32(len(data)))
+	tampered = append(tampered, data...)
+	tampered = append(tampered, rest...)
+
+	_, _, err = utxo.LoadSnapshot(t.TempDir(), bytes.NewReader(tampered), p)
+	if err == nil {
+		t.Fatal("loaded a snapshot with more entries than it contains")
+	}
+}
+
+func TestSnapshotFailurePersists(t *testing.T) {
+	c := newTestChain(t)
+	for i := 0; i < 3; i++ {
+		c.Mine(c.Tip())
+	}
+	tip := c.Tip()
+
+	// Roll the set back a block, so that the snapshot claims a set that the
+	// history does not lead to.
+	err := c.set.Disconnect(tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, metadata, p := dumpSnapshot(t, c)
+
+	bc, _, err := utxo.LoadSnapshot(t.TempDir(), bytes.NewReader(snapshot), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bc.CloseDB()
+	set := utxo.UTXOSet{Blockchain: bc}
+
+	for height := 0; height <= metadata.Height; height++ {
+		block, err := c.BC.GetBlockByHeight(height)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = set.ValidateSnapshotBlock(block)
+		if height < metadata.Height && err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		if height == metadata.Height && !errors.Is(err, utxo.ErrSnapshotInvalid) {
+			t.Fatalf("got %v, want %v", err, utxo.ErrSnapshotInvalid)
+		}
+	}
+
+	status, err := set.SnapshotStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Failure == "" || status.Validated {
+		t.Fatalf("failure was not recorded: %+v", status)
+	}
+
+	_, _, err = set.GetBalance([]byte{})
+	if !errors.Is(err, utxo.ErrSnapshotInvalid) {
+		t.Fatalf("got %v, want %v", err, utxo.ErrSnapshotInvalid)
+	}
+
+	genesis, err := c.BC.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = set.ValidateSnapshotBlock(genesis)
+	if !errors.Is(err, utxo.ErrSnapshotInvalid) {
+		t.Fatalf("got %v, want %v", err, utxo.ErrSnapshotInvalid)
+	}
+}
@@ -0,0 +1,66 @@
+package utxo
+
+import (
+	"amdzy/gochain/pkg/storage"
+	"amdzy/gochain/pkg/transactions"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const undoBucket = "undo"
+
+type spentOutput struct {
+	Txid     []byte
+	Vout     int
+	Output   transactions.TXOutput
+	Height   int
+	Coinbase bool
+}
+
+// blockUndo holds the outputs a block consumed, in the order it spent them,
+// so the block can be disconnected without rescanning the chain.
+type blockUndo struct {
+	SpentOutputs []spentOutput
+}
+
+func putUndo(tx storage.Tx, blockHash []byte, undo blockUndo) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	undoBytes, err := msgpack.Marshal(undo)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(blockHash, undoBytes)
+}
+
+func getUndo(tx storage.Tx, blockHash []byte) (blockUndo, error) {
+	var undo blockUndo
+
+	b := tx.Bucket([]byte(undoBucket))
+	if b == nil {
+		return undo, fmt.Errorf("no undo data for block %x", blockHash)
+	}
+
+	undoBytes := b.Get(blockHash)
+	if undoBytes == nil {
+		return undo, fmt.Errorf("no undo data for block %x", blockHash)
+	}
+
+	err := msgpack.Unmarshal(undoBytes, &undo)
+
+	return undo, err
+}
+
+func deleteUndo(tx storage.Tx, blockHash []byte) error {
+	b := tx.Bucket([]byte(undoBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(blockHash)
+}
@@ -0,0 +1,48 @@
+package utxo
+
+import (
+	"amdzy/gochain/pkg/blockchain"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDisconnectConnectRoundTrip(t *testing.T) {
+	c, set := newTestSet(t)
+
+	c.Extend(c.BC.Params.CoinbaseMaturity)
+	before := setHash(t, set)
+
+	blocks := []*blockchain.Block{c.Mine(c.Tip(), c.Spend(c.Blocks[0].Transactions[0], 1))}
+	blocks = append(blocks, c.Mine(blocks[0], c.Spend(blocks[0].Transactions[0], 1)))
+	after := setHash(t, set)
+	undo := undoRecords(t, set, blocks...)
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		err := set.Disconnect(blocks[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(setHash(t, set), before) {
+		t.Fatal("disconnecting the blocks did not restore the utxo set")
+	}
+	if got := undoRecords(t, set, blocks...); got[0] != nil || got[1] != nil {
+		t.Fatal("undo data was kept for disconnected blocks")
+	}
+
+	for _, block := range blocks {
+		err := set.Update(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(setHash(t, set), after) {
+		t.Fatal("connecting the blocks again gave a different utxo set")
+	}
+	if got := undoRecords(t, set, blocks...); !reflect.DeepEqual(got, undo) {
+		t.Fatalf("undo data = %+v, want %+v", got, undo)
+	}
+}
@@ -2,13 +2,12 @@ package utxo
 
 import (
 	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/storage"
 	"amdzy/gochain/pkg/transactions"
 	"amdzy/gochain/pkg/wallet"
 	"encoding/hex"
 	"errors"
-	"log"
-
-	bolt "go.etcd.io/bbolt"
+	"fmt"
 )
 
 const utxoBucket = "utxo"
@@ -18,46 +17,42 @@ type UTXOSet struct {
 }
 
 func (u UTXOSet) ReIndex() error {
-	db := u.Blockchain.Db.Db
-	bucketName := []byte(utxoBucket)
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			return err
-		}
-
-		_, err = tx.CreateBucket(bucketName)
-		if err != nil {
-			return err
-		}
+	db := u.Blockchain.Db
 
-		return nil
-	})
+	pruneHeight, err := u.Blockchain.PruneHeight()
 	if err != nil {
 		return err
 	}
 
-	UTXO, err := u.Blockchain.FindUTXO()
+	if pruneHeight > 0 {
+		return fmt.Errorf("%w: the utxo set cannot be rebuilt on a pruned node", blockchain.ErrPruned)
+	}
+
+	blockHashes, err := u.Blockchain.GetBlockHashes()
 	if err != nil {
 		return err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
+	err = db.Update(func(tx storage.Tx) error {
+		for _, bucketName := range []string{utxoBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != storage.ErrBucketNotFound {
+				return err
+			}
 
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
+			_, err = tx.CreateBucket([]byte(bucketName))
 			if err != nil {
 				return err
 			}
+		}
 
-			outsSerialized, err := outs.Serialize()
+		for i := len(blockHashes) - 1; i >= 0; i-- {
+			block, err := blockchain.GetBlockTx(tx, blockHashes[i])
 			if err != nil {
 				return err
 			}
 
-			err = b.Put(key, outsSerialized)
+			err = u.ConnectBlock(tx, block)
 			if err != nil {
 				return err
 			}
@@ -72,9 +67,19 @@ func (u UTXOSet) ReIndex() error {
 func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
-	db := u.Blockchain.Db.Db
+	db := u.Blockchain.Db
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = db.View(func(tx storage.Tx) error {
+		err := checkSnapshot(tx)
+		if err != nil {
+			return err
+		}
 
-	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -85,6 +90,10 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 				return err
 			}
 
+			if !u.isMature(outs, bestHeight+1) {
+				continue
+			}
+
 			for outIdx, out := range outs.Outputs {
 				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
 					accumulated += out.Value
@@ -105,9 +114,14 @@ func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[s
 
 func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]transactions.TXOutput, error) {
 	var UTXOs []transactions.TXOutput
-	db := u.Blockchain.Db.Db
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx storage.Tx) error {
+		err := checkSnapshot(tx)
+		if err != nil {
+			return err
+		}
 
-	err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -133,11 +147,106 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]transactions.TXOutput, error) {
 	return UTXOs, nil
 }
 
+func (u UTXOSet) FindOutputs(txID []byte) (*transactions.TXOutputs, error) {
+	var outs *transactions.TXOutputs
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx storage.Tx) error {
+		var err error
+		outs, err = fetchOutputs(tx.Bucket([]byte(utxoBucket)), txID)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return outs, nil
+}
+
+// FetchOutputs makes the utxo set a blockchain.UTXOView, blocks are checked
+// against it as they are connected.
+func (u UTXOSet) FetchOutputs(dbTx storage.Tx, txID []byte) (*transactions.TXOutputs, error) {
+	b := dbTx.Bucket([]byte(utxoBucket))
+	if b == nil {
+		return nil, errors.New("utxo set not found, run reindexutxo first")
+	}
+
+	return fetchOutputs(b, txID)
+}
+
+func fetchOutputs(b storage.Bucket, txID []byte) (*transactions.TXOutputs, error) {
+	outsBytes := b.Get(txID)
+	if outsBytes == nil {
+		return nil, nil
+	}
+
+	outs, err := transactions.DeserializeOutputs(outsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &outs, nil
+}
+
+func (u UTXOSet) GetBalance(pubKeyHash []byte) (int, int, error) {
+	spendable := 0
+	immature := 0
+	db := u.Blockchain.Db
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = db.View(func(tx storage.Tx) error {
+		err := checkSnapshot(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := transactions.DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			mature := u.isMature(outs, bestHeight+1)
+
+			for _, out := range outs.Outputs {
+				if !out.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+
+				if mature {
+					spendable += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return spendable, immature, nil
+}
+
+func (u UTXOSet) isMature(outs transactions.TXOutputs, spendHeight int) bool {
+	return !outs.Coinbase || spendHeight-outs.Height >= u.Blockchain.Params.CoinbaseMaturity
+}
+
 func (u UTXOSet) CountTransactions() (int, error) {
-	db := u.Blockchain.Db.Db
+	db := u.Blockchain.Db
 	counter := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx storage.Tx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
@@ -156,69 +265,150 @@ func (u UTXOSet) CountTransactions() (int, error) {
 }
 
 func (u UTXOSet) Update(block *blockchain.Block) error {
-	db := u.Blockchain.Db.Db
+	db := u.Blockchain.Db
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+	return db.Update(func(tx storage.Tx) error {
+		return u.ConnectBlock(tx, block)
+	})
+}
+
+func (u UTXOSet) Disconnect(block *blockchain.Block) error {
+	db := u.Blockchain.Db
+
+	return db.Update(func(tx storage.Tx) error {
+		return u.DisconnectBlock(tx, block)
+	})
+}
+
+func (u UTXOSet) ConnectBlock(dbTx storage.Tx, block *blockchain.Block) error {
+	b := dbTx.Bucket([]byte(utxoBucket))
+	if b == nil {
+		return errors.New("utxo set not found, run reindexutxo first")
+	}
+
+	undo, err := connectOutputs(b, block)
+	if err != nil {
+		return err
+	}
+
+	return putUndo(dbTx, block.Hash, undo)
+}
 
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase() {
-				for _, vin := range tx.Vin {
-					updatedOuts := transactions.TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs, err := transactions.DeserializeOutputs(outsBytes)
-					if err != nil {
-						return err
-					}
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
-
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							return err
-						}
-					} else {
-						serializedOut, err := updatedOuts.Serialize()
-						if err != nil {
-							return err
-						}
-
-						err = b.Put(vin.Txid, serializedOut)
-						if err != nil {
-							return err
-						}
-					}
+// connectOutputs removes the outputs block spends from b and adds the ones
+// it creates, returning what was spent.
+func connectOutputs(b storage.Bucket, block *blockchain.Block) (blockUndo, error) {
+	var undo blockUndo
+
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin {
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return undo, fmt.Errorf("output %x:%d is already spent", vin.Txid, vin.Vout)
+				}
+
+				outs, err := transactions.DeserializeOutputs(outsBytes)
+				if err != nil {
+					return undo, err
+				}
 
+				out, ok := outs.Outputs[vin.Vout]
+				if !ok {
+					return undo, fmt.Errorf("output %x:%d is already spent", vin.Txid, vin.Vout)
+				}
+				delete(outs.Outputs, vin.Vout)
+
+				undo.SpentOutputs = append(undo.SpentOutputs, spentOutput{vin.Txid, vin.Vout, out, outs.Height, outs.Coinbase})
+
+				err = putOutputs(b, vin.Txid, outs)
+				if err != nil {
+					return undo, err
 				}
 			}
+		}
+
+		newOutputs := transactions.TXOutputs{
+			Outputs:  make(map[int]transactions.TXOutput),
+			Height:   block.Height,
+			Coinbase: tx.IsCoinbase(),
+		}
+		for outIdx, out := range tx.Vout {
+			newOutputs.Outputs[outIdx] = out
+		}
+
+		err := putOutputs(b, tx.ID, newOutputs)
+		if err != nil {
+			return undo, err
+		}
+	}
+
+	return undo, nil
+}
+
+func (u UTXOSet) DisconnectBlock(dbTx storage.Tx, block *blockchain.Block) error {
+	b := dbTx.Bucket([]byte(utxoBucket))
+	if b == nil {
+		return errors.New("utxo set not found, run reindexutxo first")
+	}
 
-			newOutputs := transactions.TXOutputs{}
+	undo, err := getUndo(dbTx, block.Hash)
+	if err != nil {
+		return err
+	}
 
-			newOutputs.Outputs = append(newOutputs.Outputs, tx.Vout...)
+	for _, tx := range block.Transactions {
+		err := b.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+	}
 
-			serializedOut, err := newOutputs.Serialize()
+	for i := len(undo.SpentOutputs) - 1; i >= 0; i-- {
+		spent := undo.SpentOutputs[i]
+
+		outs := transactions.TXOutputs{
+			Outputs:  make(map[int]transactions.TXOutput),
+			Height:   spent.Height,
+			Coinbase: spent.Coinbase,
+		}
+		outsBytes := b.Get(spent.Txid)
+		if outsBytes != nil {
+			outs, err = transactions.DeserializeOutputs(outsBytes)
 			if err != nil {
 				return err
 			}
+		}
+		outs.Outputs[spent.Vout] = spent.Output
 
-			err = b.Put(tx.ID, serializedOut)
-			if err != nil {
-				log.Panic(err)
-			}
+		err = putOutputs(b, spent.Txid, outs)
+		if err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return deleteUndo(dbTx, block.Hash)
+}
 
-	return err
+// PruneBlock drops the undo data of a pruned block, it can no longer be
+// disconnected anyway.
+func (u UTXOSet) PruneBlock(dbTx storage.Tx, block *blockchain.Block) error {
+	return deleteUndo(dbTx, block.Hash)
+}
+
+func putOutputs(b storage.Bucket, txID []byte, outs transactions.TXOutputs) error {
+	if len(outs.Outputs) == 0 {
+		return b.Delete(txID)
+	}
+
+	outsSerialized, err := outs.Serialize()
+	if err != nil {
+		return err
+	}
+
+	return b.Put(txID, outsSerialized)
 }
 
-func NewUTXOTransaction(ws *wallet.Wallet, to string, amount int, UTXOSet *UTXOSet) (*transactions.Transaction, error) {
+func NewUTXOTransaction(ws *wallet.Wallet, to string, amount, fee int, UTXOSet *UTXOSet) (*transactions.Transaction, error) {
 	var inputs []transactions.TXInput
 	var outputs []transactions.TXOutput
 
@@ -227,12 +417,12 @@ func NewUTXOTransaction(ws *wallet.Wallet, to string, amount int, UTXOSet *UTXOS
 		return nil, err
 	}
 
-	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, amount+fee)
 	if err != nil {
 		return nil, err
 	}
 
-	if acc < amount {
+	if acc < amount+fee {
 		return nil, errors.New("not enough funds")
 	}
 
@@ -243,28 +433,27 @@ func NewUTXOTransaction(ws *wallet.Wallet, to string, amount int, UTXOSet *UTXOS
 		}
 
 		for _, out := range outs {
-			input := transactions.TXInput{Txid: txID, Vout: out, Signature: nil, PubKey: ws.PublicKey}
+			input := transactions.TXInput{Txid: txID, Vout: out, ScriptSig: nil}
 			inputs = append(inputs, input)
 		}
 	}
 
 	// Build a list of outputs
-	wsAddr, err := ws.GetAddress()
+	wsAddr, err := ws.GetAddress(UTXOSet.Blockchain.Params.AddressVersion)
 	if err != nil {
 		return nil, err
 	}
 	from := string(wsAddr)
 	outputs = append(outputs, *transactions.NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *transactions.NewTXOutput(acc-amount, from)) // a change
+	if acc > amount+fee {
+		outputs = append(outputs, *transactions.NewTXOutput(acc-amount-fee, from)) // a change
 	}
 
 	tx := transactions.Transaction{ID: nil, Vin: inputs, Vout: outputs}
-	tx.ID, err = tx.Hash()
+	err = UTXOSet.Blockchain.SignTransaction(&tx, ws.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
-	UTXOSet.Blockchain.SignTransaction(&tx, ws.PrivateKey)
 
 	return &tx, nil
 }
@@ -0,0 +1,122 @@
+package utxo_test
+
+import (
+	"amdzy/gochain/internal/chaintest"
+	"amdzy/gochain/pkg/blockchain"
+	"amdzy/gochain/pkg/transactions"
+	"amdzy/gochain/pkg/utxo"
+	"context"
+	"errors"
+	"testing"
+)
+
+type testChain struct {
+	*chaintest.Chain
+	set utxo.UTXOSet
+}
+
+// newTestChain starts a chain that keeps its UTXO set.
+func newTestChain(t *testing.T) *testChain {
+	c := chaintest.New(t)
+
+	set := utxo.UTXOSet{Blockchain: c.BC}
+	err := set.ReIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BC.AddIndex(set)
+
+	return &testChain{Chain: c, set: set}
+}
+
+func TestRejectsForgedTxID(t *testing.T) {
+	c := newTestChain(t)
+
+	genesis, err := c.BC.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := genesis.Transactions[0]
+
+	tip := genesis
+	for i := 0; i < c.BC.Params.CoinbaseMaturity; i++ {
+		tip = c.Mine(tip)
+	}
+
+	forged := c.Spend(tip.Transactions[0], 0)
+	forged.ID = victim.ID
+
+	err = c.BC.AddBlock(c.Block(tip, forged))
+	if !errors.Is(err, blockchain.ErrBadTxID) || !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrBadTxID)
+	}
+
+	outs, err := c.set.FindOutputs(victim.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outs == nil || outs.Outputs[0].Value != victim.Vout[0].Value {
+		t.Fatalf("outputs of %x were overwritten", victim.ID)
+	}
+}
+
+func TestRejectsDuplicateTxID(t *testing.T) {
+	c := newTestChain(t)
+
+	genesis, err := c.BC.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The genesis coinbase pays the same address with the same data, so
+	// this copy has the same id while the original is still unspent.
+	duplicate, err := transactions.NewCoinbaseTX(c.Address, c.BC.Params.GenesisCoinbaseData, c.BC.Params.BlockSubsidy(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := blockchain.NewBlock(context.Background(), c.BC.Engine, c.BC, []*transactions.Transaction{duplicate}, genesis.Hash, 1, genesis.Timestamp+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.BC.AddBlock(block)
+	if !errors.Is(err, blockchain.ErrDuplicateTx) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrDuplicateTx)
+	}
+}
+
+func TestRejectsSideBranchDoubleSpend(t *testing.T) {
+	c := newTestChain(t)
+
+	genesis, err := c.BC.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tip := genesis
+	for i := 0; i < c.BC.Params.CoinbaseMaturity; i++ {
+		tip = c.Mine(tip)
+	}
+
+	fork := tip
+	main := c.Mine(fork, c.Spend(genesis.Transactions[0], 1))
+	main = c.Mine(main)
+
+	// The first spend on the side branch is valid there, the second spends
+	// the same output again.
+	side := c.Mine(fork, c.Spend(genesis.Transactions[0], 2))
+
+	err = c.BC.AddBlock(c.Block(side, c.Spend(genesis.Transactions[0], 3)))
+	if !errors.Is(err, blockchain.ErrMissingOutput) || !errors.Is(err, blockchain.ErrInvalidTransaction) {
+		t.Fatalf("got %v, want %v", err, blockchain.ErrMissingOutput)
+	}
+
+	best, err := c.BC.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best != main.Height {
+		t.Fatalf("best height %d, want %d", best, main.Height)
+	}
+}
@@ -7,20 +7,24 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const addressChecksumLen = 4
-const version = byte(0x00)
 const walletFile = "wallets.dat"
+const publicKeyLen = 64
+
+var ErrBadPublicKey = errors.New("public key is malformed")
 
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
 	PublicKey  []byte
 }
 
-func (w Wallet) GetAddress() ([]byte, error) {
+func (w Wallet) GetAddress(version byte) ([]byte, error) {
 	pubKeyHash, err := HashPubKey(w.PublicKey)
 	if err != nil {
 		return nil, err
@@ -55,10 +59,16 @@ func checksum(payload []byte) []byte {
 	return secondSHA[:addressChecksumLen]
 }
 
-func ValidateAddress(address string) bool {
+func ValidateAddress(address string, version byte) bool {
 	pubKeyHash := utils.Base58Decode([]byte(address))
+	if len(pubKeyHash) <= 1+addressChecksumLen {
+		return false
+	}
+
 	actualChecksum := pubKeyHash[len(pubKeyHash)-addressChecksumLen:]
-	version := pubKeyHash[0]
+	if pubKeyHash[0] != version {
+		return false
+	}
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-addressChecksumLen]
 	targetChecksum := checksum(append([]byte{version}, pubKeyHash...))
 
@@ -81,6 +91,27 @@ func NewKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	if err != nil {
 		return ecdsa.PrivateKey{}, nil, err
 	}
-	public := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	public := EncodePublicKey(&private.PublicKey)
 	return *private, public, nil
 }
+
+// EncodePublicKey encodes pub as its X and Y coordinates, each padded to
+// half of the 64 bytes.
+func EncodePublicKey(pub *ecdsa.PublicKey) []byte {
+	public := make([]byte, publicKeyLen)
+	pub.X.FillBytes(public[:publicKeyLen/2])
+	pub.Y.FillBytes(public[publicKeyLen/2:])
+
+	return public
+}
+
+func DecodePublicKey(public []byte) (*ecdsa.PublicKey, error) {
+	if len(public) != publicKeyLen {
+		return nil, ErrBadPublicKey
+	}
+
+	x := new(big.Int).SetBytes(public[:publicKeyLen/2])
+	y := new(big.Int).SetBytes(public[publicKeyLen/2:])
+
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
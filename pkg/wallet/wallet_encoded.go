@@ -24,5 +24,11 @@ func decodeWallet(encWallet walletEncoded) Wallet {
 	x509Encoded := block.Bytes
 	privateKey, _ := x509.ParseECPrivateKey(x509Encoded)
 
-	return Wallet{PrivateKey: *privateKey, PublicKey: encWallet.PublicKey}
+	// The public key is derived again rather than read, as wallets created
+	// before keys were padded to their full length stored it unpadded. For
+	// such a wallet this changes the public key, and with it the address:
+	// the wallet is listed under the address of the padded key only, and
+	// outputs paid to the old address cannot be spent, since their key does
+	// not decode any more.
+	return Wallet{PrivateKey: *privateKey, PublicKey: EncodePublicKey(&privateKey.PublicKey)}
 }
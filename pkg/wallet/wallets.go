@@ -3,12 +3,15 @@ package wallet
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+	file    string
+	version byte
 }
 
 func (ws *Wallets) CreateWallet() (string, error) {
@@ -17,7 +20,7 @@ func (ws *Wallets) CreateWallet() (string, error) {
 		return "", err
 	}
 
-	addr, err := wallet.GetAddress()
+	addr, err := wallet.GetAddress(ws.version)
 	if err != nil {
 		return "", err
 	}
@@ -49,12 +52,12 @@ func (ws *Wallets) GetWallet(address string) (Wallet, error) {
 }
 
 func (ws *Wallets) LoadFromFile() error {
-	_, err := os.Stat(walletFile)
+	_, err := os.Stat(ws.file)
 	if os.IsNotExist(err) {
 		return nil
 	}
 
-	fileContent, err := os.ReadFile(walletFile)
+	fileContent, err := os.ReadFile(ws.file)
 	if err != nil {
 		return err
 	}
@@ -72,7 +75,7 @@ func (ws *Wallets) LoadFromFile() error {
 	for _, encWs := range encWallets {
 		wallet := decodeWallet(encWs)
 
-		addr, err := wallet.GetAddress()
+		addr, err := wallet.GetAddress(ws.version)
 		if err != nil {
 			return err
 		}
@@ -98,14 +101,14 @@ func (ws *Wallets) SaveToFile() error {
 		return err
 	}
 
-	os.WriteFile(walletFile, b, 0644)
-
-	return nil
+	return os.WriteFile(ws.file, b, 0600)
 }
 
-func NewWallets() (*Wallets, error) {
+func NewWallets(dataDir string, version byte) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.file = filepath.Join(dataDir, walletFile)
+	wallets.version = version
 
 	err := wallets.LoadFromFile()
 	if err != nil {
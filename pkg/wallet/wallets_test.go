@@ -0,0 +1,75 @@
+package wallet
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Wallets saved before public keys were padded have them stored without
+// the leading zeros of their coordinates.
+func TestLoadUnpaddedWallet(t *testing.T) {
+	var w *Wallet
+	for w == nil || len(w.PrivateKey.PublicKey.X.Bytes()) == publicKeyLen/2 {
+		var err error
+		w, err = NewWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unpadded := append(w.PrivateKey.PublicKey.X.Bytes(), w.PrivateKey.PublicKey.Y.Bytes()...)
+	encoded := encodeWallet(w)
+	encoded.PublicKey = unpadded
+
+	data, err := msgpack.Marshal([]walletEncoded{encoded})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := t.TempDir()
+	err = os.WriteFile(filepath.Join(dataDir, walletFile), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := NewWallets(dataDir, 0x6f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := w.GetAddress(0x6f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ws.GetWallet(string(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.PublicKey, w.PublicKey) {
+		t.Fatalf("public key = %x, want %x", loaded.PublicKey, w.PublicKey)
+	}
+
+	// Loading moved the wallet from the address of its unpadded key.
+	oldAddress, err := Wallet{PublicKey: unpadded}.GetAddress(0x6f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(oldAddress, address) {
+		t.Fatal("padding the key did not change the address")
+	}
+
+	addresses := ws.GetAddresses()
+	if len(addresses) != 1 || addresses[0] != string(address) {
+		t.Fatalf("addresses = %v, want [%s]", addresses, address)
+	}
+
+	_, err = ws.GetWallet(string(oldAddress))
+	if err == nil {
+		t.Fatalf("wallet is still listed under its old address %s", oldAddress)
+	}
+}